@@ -66,7 +66,7 @@ func (c *Client) exchange(ctx context.Context, req, resp *Message) error {
 	}
 
 	resp.Raw = resp.Raw[:n]
-	err = ParseMessage(resp, resp.Raw, false)
+	err = ParseResponse(resp, resp.Raw, false)
 	if err != nil {
 		return err
 	}
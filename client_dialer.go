@@ -5,10 +5,13 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"reflect"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 	"unsafe"
@@ -145,18 +148,32 @@ func (c *tcpConn) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Read reads a length prefixed message into b. It returns io.EOF if the connection is closed before the
+// length prefix, and io.ErrUnexpectedEOF if it is closed in the middle of a message. A message longer than b
+// is discarded with io.ErrShortBuffer.
 func (c *tcpConn) Read(b []byte) (n int, err error) {
-	c.buffer = c.buffer[:cap(c.buffer)]
-	n, err = c.Conn.Read(c.buffer)
-	if err != nil {
-		return
+	c.buffer = c.buffer[:2]
+	if _, err = io.ReadFull(c.Conn, c.buffer); err != nil {
+		return 0, err
+	}
+	n = int(c.buffer[0])<<8 | int(c.buffer[1])
+	if n > len(b) {
+		// discard the message, so that the next read starts at a length prefix
+		if _, err = io.CopyN(io.Discard, c.Conn, int64(n)); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		return 0, io.ErrShortBuffer
 	}
-	m := int(c.buffer[0])<<8 | int(c.buffer[1])
-	if m+2 != n {
-		return 0, ErrInvalidAnswer
+	if _, err = io.ReadFull(c.Conn, b[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
 	}
-	copy(b, c.buffer[2:n])
-	return n - 2, nil
+	return n, nil
 }
 
 // HTTPDialer is a custom dialer for creating HTTP connections.
@@ -174,6 +191,10 @@ type HTTPDialer struct {
 	// It can be customized for specific needs, E.g. User-Agent.
 	Header http.Header
 
+	// ErrorLog specifies an optional logger for warnings about the responses,
+	// E.g. a content type other than application/dns-message.
+	ErrorLog *slog.Logger
+
 	once sync.Once
 	pool sync.Pool
 }
@@ -226,6 +247,28 @@ type httpConn struct {
 	resp   []byte
 }
 
+// httpFreshness returns the max-age of the Cache-Control header minus the Age header, ok is false without max-age.
+func httpFreshness(header http.Header) (ttl uint32, ok bool) {
+	var maxAge int64 = -1
+	for _, v := range header.Values("cache-control") {
+		for _, directive := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if strings.EqualFold(name, "max-age") {
+				if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+					maxAge = int64(n)
+				}
+			}
+		}
+	}
+	if maxAge < 0 {
+		return 0, false
+	}
+	if age, err := strconv.ParseUint(header.Get("age"), 10, 32); err == nil {
+		maxAge = max(maxAge-int64(age), 0)
+	}
+	return uint32(maxAge), true
+}
+
 func (c *httpConn) Read(b []byte) (n int, err error) {
 	if c.resp == nil {
 		err = io.EOF
@@ -261,13 +304,30 @@ func (c *httpConn) Write(b []byte) (n int, err error) {
 	}
 	defer resp.Body.Close()
 
-	_, err = io.Copy(c.writer, resp.Body)
+	// the body may be chunked without content length, and is read up to the max size of dns message.
+	_, err = io.Copy(c.writer, io.LimitReader(resp.Body, 65535+1))
 	if err != nil {
 		return 0, fmt.Errorf("fastdns: read from %s error: %w", c.dialer.Endpoint, err)
 	}
-	if resp.StatusCode != http.StatusOK || resp.ContentLength <= 0 {
+	if resp.StatusCode != http.StatusOK || len(c.writer.B) == 0 {
 		return 0, fmt.Errorf("fastdns: read from %s error: %s: %s", c.dialer.Endpoint, resp.Status, c.writer.B)
 	}
+	if len(c.writer.B) > 65535 {
+		return 0, fmt.Errorf("fastdns: read from %s error: response body exceeds 65535 bytes", c.dialer.Endpoint)
+	}
+
+	// some endpoints send a wrong content type, the body is parsed anyway.
+	if ct := resp.Header.Get("content-type"); ct != "application/dns-message" && c.dialer.ErrorLog != nil {
+		c.dialer.ErrorLog.Warn("fastdns: unexpected content type of doh response", "endpoint", c.dialer.Endpoint.String(), "content_type", ct)
+	}
+
+	// the freshness lifetime of RFC 8484 section 5.1 caps the ttls, so that a cache of the response expires with it.
+	if ttl, ok := httpFreshness(resp.Header); ok {
+		msg := Message{Raw: c.writer.B}
+		if ParseResponse(&msg, msg.Raw, false) == nil {
+			_ = msg.CapTTLs(ttl)
+		}
+	}
 
 	c.resp = c.writer.B
 	return len(b), nil
@@ -4,6 +4,7 @@ import (
 	"cmp"
 	"context"
 	"encoding/binary"
+	"errors"
 	"net"
 	"net/netip"
 	"slices"
@@ -36,7 +37,9 @@ func (c *Client) AppendLookupNetIP(dst []netip.Addr, ctx context.Context, networ
 		return nil, ErrInvalidQuestion
 	}
 
-	req.SetRequestQuestion(host, typ, ClassINET)
+	if err = req.SetRequestQuestionChecked(host, typ, ClassINET); err != nil {
+		return nil, err
+	}
 
 	err = c.Exchange(ctx, req, resp)
 	if err != nil {
@@ -68,13 +71,41 @@ func (c *Client) LookupNetIP(ctx context.Context, network, host string) (ips []n
 	return c.AppendLookupNetIP(ips, ctx, network, host)
 }
 
+// LookupHost looks up the IPv4 and IPv6 addresses of host concurrently using the local resolver.
+// If one of the lookups fails, the addresses of the other one are returned without error. If both fail,
+// the error joins the errors of the IPv4 and the IPv6 lookups, in that order.
+func (c *Client) LookupHost(ctx context.Context, host string) (ips []netip.Addr, err error) {
+	var ip6s []netip.Addr
+	var err6 error
+
+	done := make(chan struct{})
+	go func() {
+		ip6s, err6 = c.AppendLookupNetIP(nil, ctx, "ip6", host)
+		close(done)
+	}()
+
+	ips, err = c.AppendLookupNetIP(ips, ctx, "ip4", host)
+	<-done
+
+	switch {
+	case err != nil && err6 != nil:
+		return nil, errors.Join(err, err6)
+	case err != nil:
+		return ip6s, nil
+	}
+
+	return append(ips, ip6s...), nil
+}
+
 // LookupCNAME returns the canonical name for the given host.
 func (c *Client) LookupCNAME(ctx context.Context, host string) (cname string, err error) {
 	req, resp := AcquireMessage(), AcquireMessage()
 	defer ReleaseMessage(resp)
 	defer ReleaseMessage(req)
 
-	req.SetRequestQuestion(host, TypeCNAME, ClassINET)
+	if err = req.SetRequestQuestionChecked(host, TypeCNAME, ClassINET); err != nil {
+		return
+	}
 
 	err = c.Exchange(ctx, req, resp)
 	if err != nil {
@@ -99,7 +130,9 @@ func (c *Client) LookupNS(ctx context.Context, name string) (ns []*net.NS, err e
 	defer ReleaseMessage(resp)
 	defer ReleaseMessage(req)
 
-	req.SetRequestQuestion(name, TypeNS, ClassINET)
+	if err = req.SetRequestQuestionChecked(name, TypeNS, ClassINET); err != nil {
+		return
+	}
 
 	err = c.Exchange(ctx, req, resp)
 	if err != nil {
@@ -132,23 +165,29 @@ func (c *Client) LookupTXT(ctx context.Context, host string) (txt []string, err
 	defer ReleaseMessage(resp)
 	defer ReleaseMessage(req)
 
-	req.SetRequestQuestion(host, TypeTXT, ClassINET)
+	if err = req.SetRequestQuestionChecked(host, TypeTXT, ClassINET); err != nil {
+		return
+	}
 
 	err = c.Exchange(ctx, req, resp)
 	if err != nil {
 		return
 	}
 
+	// the first malformed or unexpected record is reported after the valid ones are collected
 	for r := range resp.Records {
 		switch r.Type {
 		case TypeTXT:
-			if len(r.Data) > 1 && int(r.Data[0])+1 == len(r.Data) {
-				txt = append(txt, string(r.Data[1:]))
-			} else {
-				err = ErrInvalidAnswer
+			b, e := DecodeTXT(nil, r.Data)
+			if e == nil {
+				txt = append(txt, string(b))
+			} else if err == nil {
+				err = e
 			}
 		default:
-			err = ErrInvalidAnswer
+			if err == nil {
+				err = ErrInvalidAnswer
+			}
 		}
 	}
 
@@ -161,7 +200,9 @@ func (c *Client) LookupMX(ctx context.Context, host string) (mx []*net.MX, err e
 	defer ReleaseMessage(resp)
 	defer ReleaseMessage(req)
 
-	req.SetRequestQuestion(host, TypeMX, ClassINET)
+	if err = req.SetRequestQuestionChecked(host, TypeMX, ClassINET); err != nil {
+		return
+	}
 
 	err = c.Exchange(ctx, req, resp)
 	if err != nil {
@@ -186,7 +227,9 @@ func (c *Client) LookupHTTPS(ctx context.Context, host string) (https []NetHTTPS
 	defer ReleaseMessage(resp)
 	defer ReleaseMessage(req)
 
-	req.SetRequestQuestion(host, TypeHTTPS, ClassINET)
+	if err = req.SetRequestQuestionChecked(host, TypeHTTPS, ClassINET); err != nil {
+		return
+	}
 
 	err = c.Exchange(ctx, req, resp)
 	if err != nil {
@@ -258,7 +301,9 @@ func (c *Client) LookupSRV(ctx context.Context, service, proto, name string) (ta
 	defer ReleaseMessage(resp)
 	defer ReleaseMessage(req)
 
-	req.SetRequestQuestion(target, TypeSRV, ClassINET)
+	if err = req.SetRequestQuestionChecked(target, TypeSRV, ClassINET); err != nil {
+		return
+	}
 
 	err = c.Exchange(ctx, req, resp)
 	if err != nil {
@@ -1,10 +1,14 @@
 package fastdns
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"net/url"
 	"os"
@@ -65,6 +69,206 @@ func TestClientExchange(t *testing.T) {
 	}
 }
 
+func TestClientExchangeHTTPChunked(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req := AcquireMessage()
+		defer ReleaseMessage(req)
+
+		payload, _ := io.ReadAll(r.Body)
+		if err := ParseMessage(req, payload, true); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req.SetResponseHeader(RcodeNoError, 1)
+		body := AppendHOST1Record(req.Raw, req, 300, netip.AddrFrom4([4]byte{1, 2, 4, 8}))
+
+		// no content length, and an unexpected content type
+		rw.Header().Set("content-type", "application/octet-stream")
+		// a freshness lifetime of 100 seconds, lower than the TTL
+		rw.Header().Set("cache-control", "public, max-age=120")
+		rw.Header().Set("age", "20")
+		for i := 0; i < len(body); i += 16 {
+			rw.Write(body[i:min(i+16, len(body))])
+			rw.(http.Flusher).Flush()
+		}
+	}))
+	defer server.Close()
+
+	var log bytes.Buffer
+	client := &Client{
+		Dialer: &HTTPDialer{
+			Endpoint: func() (u *url.URL) { u, _ = url.Parse(server.URL + "/dns-query"); return }(),
+			ErrorLog: slog.New(slog.NewTextHandler(&log, nil)),
+		},
+	}
+
+	ips, err := client.LookupNetIP(context.Background(), "ip4", "example.org")
+	if err != nil {
+		t.Fatalf("client lookup from chunked response error: %+v", err)
+	}
+	if len(ips) != 1 || ips[0] != netip.AddrFrom4([4]byte{1, 2, 4, 8}) {
+		t.Errorf("client lookup from chunked response got=%v", ips)
+	}
+	if !bytes.Contains(log.Bytes(), []byte("content_type=application/octet-stream")) {
+		t.Errorf("client lookup from chunked response shall warn about the content type, got log=%q", log.Bytes())
+	}
+
+	req, resp := AcquireMessage(), AcquireMessage()
+	defer ReleaseMessage(resp)
+	defer ReleaseMessage(req)
+
+	req.SetRequestQuestion("example.org", TypeA, ClassINET)
+	if err := client.Exchange(context.Background(), req, resp); err != nil {
+		t.Fatalf("client exchange from chunked response error: %+v", err)
+	}
+	if ttl, ok := resp.AnswerTTL(0); !ok || ttl != 100 {
+		t.Errorf("client exchange from chunked response got ttl=%d want=100", ttl)
+	}
+}
+
+func TestTCPConnReadEOF(t *testing.T) {
+	var cases = []struct {
+		Stream string
+		Err    error
+	}{
+		// a message then a clean close
+		{"\x00\x03abc", io.EOF},
+		// a close in the middle of the length prefix
+		{"\x00\x03abc\x00", io.ErrUnexpectedEOF},
+		// a close in the middle of the body
+		{"\x00\x03abc\x00\x0adef", io.ErrUnexpectedEOF},
+	}
+
+	for _, c := range cases {
+		server, client := net.Pipe()
+		go func() {
+			_, _ = server.Write([]byte(c.Stream))
+			server.Close()
+		}()
+
+		conn := &tcpConn{Conn: client, buffer: make([]byte, 0, 1024)}
+		b := make([]byte, 512)
+
+		n, err := conn.Read(b)
+		if err != nil || string(b[:n]) != "abc" {
+			t.Errorf("tcpConn.Read(%q) first message got=(%q, %+v) want=(\"abc\", nil)", c.Stream, b[:n], err)
+		}
+		if _, err = conn.Read(b); err != c.Err {
+			t.Errorf("tcpConn.Read(%q) error got=%+v want=%+v", c.Stream, err, c.Err)
+		}
+
+		client.Close()
+	}
+}
+
+func TestTCPConnReadShortBuffer(t *testing.T) {
+	server, client := net.Pipe()
+	go func() {
+		_, _ = server.Write([]byte("\x00\x05abcde\x00\x03abc"))
+		server.Close()
+	}()
+	defer client.Close()
+
+	conn := &tcpConn{Conn: client, buffer: make([]byte, 0, 1024)}
+	b := make([]byte, 4)
+
+	if _, err := conn.Read(b); err != io.ErrShortBuffer {
+		t.Errorf("tcpConn.Read long message error got=%+v want=%+v", err, io.ErrShortBuffer)
+	}
+	// the long message is discarded, so the stream is still in sync
+	if n, err := conn.Read(b); err != nil || string(b[:n]) != "abc" {
+		t.Errorf("tcpConn.Read next message got=(%q, %+v) want=(\"abc\", nil)", b[:n], err)
+	}
+}
+
+func TestClientLookupHost(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req := AcquireMessage()
+		defer ReleaseMessage(req)
+
+		payload, _ := io.ReadAll(r.Body)
+		if err := ParseMessage(req, payload, true); err != nil || req.Question.Type != TypeA {
+			// fails the AAAA lookup
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		req.SetResponseHeader(RcodeNoError, 1)
+		rw.Write(AppendHOST1Record(req.Raw, req, 300, netip.AddrFrom4([4]byte{1, 2, 4, 8})))
+	}))
+	defer server.Close()
+
+	client := &Client{
+		Dialer: &HTTPDialer{
+			Endpoint: func() (u *url.URL) { u, _ = url.Parse(server.URL + "/dns-query"); return }(),
+		},
+	}
+
+	ips, err := client.LookupHost(context.Background(), "example.org")
+	if err != nil {
+		t.Fatalf("client lookup host with a failed AAAA lookup error: %+v", err)
+	}
+	if len(ips) != 1 || ips[0] != netip.AddrFrom4([4]byte{1, 2, 4, 8}) {
+		t.Errorf("client lookup host got=%v", ips)
+	}
+}
+
+func TestClientLookupHostBothFail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		// fails both the A and the AAAA lookups
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := &Client{
+		Dialer: &HTTPDialer{
+			Endpoint: func() (u *url.URL) { u, _ = url.Parse(server.URL + "/dns-query"); return }(),
+		},
+	}
+
+	ips, err := client.LookupHost(context.Background(), "example.org")
+	if err == nil || len(ips) != 0 {
+		t.Fatalf("client lookup host with failed lookups got=(%v, %+v) want an error", ips, err)
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); !ok || len(joined.Unwrap()) != 2 {
+		t.Errorf("client lookup host error got=%+v want the errors of both lookups", err)
+	}
+}
+
+func TestClientLookupTXT(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		req := AcquireMessage()
+		defer ReleaseMessage(req)
+
+		payload, _ := io.ReadAll(r.Body)
+		if err := ParseMessage(req, payload, true); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req.SetResponseHeader(RcodeNoError, 2)
+		body := req.Raw
+		// a malformed TXT whose character-string exceeds RDATA, then "v=spf1 -all"
+		body = append(body, 0xc0, 0x0c, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x03, 0x05, 'a', 'b')
+		body = append(body, 0xc0, 0x0c, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x0c, 0x0b)
+		body = append(body, "v=spf1 -all"...)
+		rw.Write(body)
+	}))
+	defer server.Close()
+
+	client := &Client{
+		Dialer: &HTTPDialer{
+			Endpoint: func() (u *url.URL) { u, _ = url.Parse(server.URL + "/dns-query"); return }(),
+		},
+	}
+
+	txt, err := client.LookupTXT(context.Background(), "example.org")
+	if err != ErrInvalidAnswer {
+		t.Errorf("client lookup txt with a malformed record error got=%+v want=%+v", err, ErrInvalidAnswer)
+	}
+	if len(txt) != 1 || txt[0] != "v=spf1 -all" {
+		t.Errorf("client lookup txt got=%q", txt)
+	}
+}
+
 func deref(value any) any {
 	v := reflect.ValueOf(value)
 	if v.Kind() != reflect.Slice {
@@ -42,7 +42,8 @@ func main() {
 	defer fastdns.ReleaseMessage(req)
 	defer fastdns.ReleaseMessage(resp)
 
-	req.SetRequestQuestion(domain, fastdns.ParseType(qtype), fastdns.ClassINET)
+	typ, _ := fastdns.ParseType(qtype)
+	req.SetRequestQuestion(domain, typ, fastdns.ClassINET)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -91,8 +92,10 @@ func parse(args []string) (domain, qtype, server string, options []string) {
 	if qtype == "" {
 		qtype = "A"
 	}
-	if fastdns.ParseType(qtype) == 0 && fastdns.ParseType(domain) != 0 {
-		domain, qtype = qtype, domain
+	if _, ok := fastdns.ParseType(qtype); !ok {
+		if _, ok := fastdns.ParseType(domain); ok {
+			domain, qtype = qtype, domain
+		}
 	}
 	return
 }
@@ -0,0 +1,96 @@
+package fastdns
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// DiffMessages returns the human readable differences between a and b in the header, the question
+// and the resource record sets, e.g. "ANCount: 2 != 1" or "answer example.org A 1.2.3.4 only in a".
+// Resource records are compared regardless of order, name case and TTL. The output is deterministic.
+func DiffMessages(a, b *Message) (diffs []string) {
+	diff := func(name string, x, y any) {
+		if x != y {
+			diffs = append(diffs, fmt.Sprintf("%s: %v != %v", name, x, y))
+		}
+	}
+
+	diff("ID", a.Header.ID, b.Header.ID)
+	diff("QR", a.Header.Flags.QR(), b.Header.Flags.QR())
+	diff("Opcode", a.Header.Flags.Opcode(), b.Header.Flags.Opcode())
+	diff("AA", a.Header.Flags.AA(), b.Header.Flags.AA())
+	diff("TC", a.Header.Flags.TC(), b.Header.Flags.TC())
+	diff("RD", a.Header.Flags.RD(), b.Header.Flags.RD())
+	diff("RA", a.Header.Flags.RA(), b.Header.Flags.RA())
+	diff("Z", a.Header.Flags.Z(), b.Header.Flags.Z())
+	diff("Rcode", a.Header.Flags.Rcode(), b.Header.Flags.Rcode())
+	diff("QDCount", a.Header.QDCount, b.Header.QDCount)
+	diff("ANCount", a.Header.ANCount, b.Header.ANCount)
+	diff("NSCount", a.Header.NSCount, b.Header.NSCount)
+	diff("ARCount", a.Header.ARCount, b.Header.ARCount)
+
+	if !equalFold(a.Domain, b.Domain) {
+		diff("Question.Name", string(a.Domain), string(b.Domain))
+	}
+	diff("Question.Type", a.Question.Type, b.Question.Type)
+	diff("Question.Class", a.Question.Class, b.Question.Class)
+
+	for _, section := range []Section{SectionAnswer, SectionAuthority, SectionAdditional} {
+		x, y := a.recordSet(section), b.recordSet(section)
+		var lines []string
+		for key, texts := range x {
+			for _, text := range texts[min(len(texts), len(y[key])):] {
+				lines = append(lines, fmt.Sprintf("%s %s only in a", section, text))
+			}
+		}
+		for key, texts := range y {
+			for _, text := range texts[min(len(texts), len(x[key])):] {
+				lines = append(lines, fmt.Sprintf("%s %s only in b", section, text))
+			}
+		}
+		slices.Sort(lines)
+		diffs = append(diffs, lines...)
+	}
+
+	return
+}
+
+// recordSet returns the texts of resource records in section of msg, grouped by the record key.
+func (msg *Message) recordSet(section Section) map[string][]string {
+	set := make(map[string][]string)
+	var buf [512]byte
+	_ = msg.walk(func(i, off, rdata, end int) bool {
+		if msg.section(i) != section {
+			return msg.section(i) < section
+		}
+		r := msg.record(off, rdata, end)
+		key := string(msg.appendRecordKey(buf[:0], r))
+		set[key] = append(set[key], msg.recordText(r))
+		return true
+	})
+	return set
+}
+
+// recordText returns the presentation of r without TTL and class, e.g. "example.org A 1.2.3.4".
+func (msg *Message) recordText(r MessageRecord) string {
+	name := string(msg.DecodeName(nil, r.Name))
+	if name == "" {
+		name = "."
+	}
+	var data string
+	switch {
+	case r.Type == TypeA && len(r.Data) == 4:
+		data = netip.AddrFrom4(*(*[4]byte)(r.Data)).String()
+	case r.Type == TypeAAAA && len(r.Data) == 16:
+		data = netip.AddrFrom16(*(*[16]byte)(r.Data)).String()
+	case r.Type == TypeCNAME, r.Type == TypeNS, r.Type == TypePTR, r.Type == TypeDNAME:
+		data = string(msg.DecodeName(nil, r.Data))
+	case r.Type == TypeMX && len(r.Data) > 2:
+		data = fmt.Sprintf("%d %s", uint16(r.Data[0])<<8|uint16(r.Data[1]), msg.DecodeName(nil, r.Data[2:]))
+	default:
+		data = hex.EncodeToString(r.Data)
+	}
+	return name + " " + r.Type.String() + " " + data
+}
@@ -0,0 +1,34 @@
+package fastdns
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestDiffMessages(t *testing.T) {
+	newResponse := func(id uint16, ips ...netip.Addr) *Message {
+		msg := new(Message)
+		msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+		msg.Header.ID = id
+		msg.SetResponseHeader(RcodeNoError, uint16(len(ips)))
+		msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, ips)
+		return msg
+	}
+
+	a := newResponse(1, netip.AddrFrom4([4]byte{1, 2, 3, 4}), netip.AddrFrom4([4]byte{8, 8, 8, 8}))
+	b := newResponse(1, netip.AddrFrom4([4]byte{8, 8, 8, 8}))
+	c := newResponse(1, netip.AddrFrom4([4]byte{8, 8, 8, 8}), netip.AddrFrom4([4]byte{1, 2, 3, 4}))
+
+	want := []string{
+		"ANCount: 2 != 1",
+		"answer example.org A 1.2.3.4 only in a",
+	}
+	if got := DiffMessages(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffMessages got=%q want=%q", got, want)
+	}
+
+	if got := DiffMessages(a, c); len(got) != 0 {
+		t.Errorf("DiffMessages of reordered responses shall be empty, got=%q", got)
+	}
+}
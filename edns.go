@@ -0,0 +1,452 @@
+package fastdns
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"net/netip"
+)
+
+// ClampUDPSize returns the EDNS UDP payload size to advertise for the requested one, clamped between
+// 512 of RFC 1035 and 1232, which avoids IP fragmentation on common paths.
+func ClampUDPSize(requested uint16) uint16 {
+	switch {
+	case requested < 512:
+		return 512
+	case requested > 1232:
+		return 1232
+	}
+	return requested
+}
+
+// EDNS0Option represents an option in the RDATA of an OPT pseudo record, see RFC 6891.
+type EDNS0Option struct {
+	Code uint16
+	Data []byte
+}
+
+// AppendOPTRecord appends an OPT pseudo record owned by root to dst, with the UDP payload size udpSize,
+// the extended RCODE extRcode, the EDNS version, the DO bit and options. Callers append it after the other
+// additional records, as some implementations expect it last, while the OPT record is found anywhere in
+// the additional section when parsing.
+func AppendOPTRecord(dst []byte, udpSize uint16, extRcode, version byte, doBit bool, options []EDNS0Option) []byte {
+	var do byte
+	if doBit {
+		do = 0b10000000
+	}
+
+	length := 0
+	for _, o := range options {
+		length += 4 + len(o.Data)
+	}
+
+	dst = append(dst,
+		// NAME
+		0x00,
+		// TYPE
+		byte(TypeOPT>>8), byte(TypeOPT),
+		// CLASS, the UDP payload size
+		byte(udpSize>>8), byte(udpSize),
+		// TTL, the extended RCODE, VERSION, DO and Z
+		extRcode, version, do, 0x00,
+		// RDLENGTH
+		byte(length>>8), byte(length),
+	)
+
+	// RDATA
+	for _, o := range options {
+		dst = append(dst, byte(o.Code>>8), byte(o.Code), byte(len(o.Data)>>8), byte(len(o.Data)))
+		dst = append(dst, o.Data...)
+	}
+
+	return dst
+}
+
+// SetFormErrWithOPT sets msg to the FORMERR response of req carrying an OPT record of udpSize without options,
+// which is how RFC 6891 requires a server to answer a query with a malformed OPT record. RD is copied from req
+// and RA is 0, the caller sets RA if it offers recursion.
+func (msg *Message) SetFormErrWithOPT(req *Message, udpSize uint16) {
+	msg.SetResponse(req)
+
+	// RA = 0
+	msg.Header.Flags &= 0b1111111101111111
+	msg.SetResponseHeader(RcodeFormErr, 0)
+
+	msg.Raw = AppendOPTRecord(msg.Raw, udpSize, 0, 0, false, nil)
+
+	msg.Header.ARCount = 1
+
+	// ARCOUNT
+	msg.Raw[10] = 0
+	msg.Raw[11] = 1
+}
+
+// SetEDNS0 appends an OPT record without options advertising udpSize and the DO bit to the additional section
+// of msg, e.g. after SetRequestQuestion, and updates ARCount and Raw. An existing OPT record is replaced.
+func (msg *Message) SetEDNS0(udpSize uint16, doBit bool) error {
+	if err := msg.RemoveOPT(); err != nil {
+		return err
+	}
+
+	msg.Raw = AppendOPTRecord(msg.Raw, udpSize, 0, 0, doBit, nil)
+
+	msg.Header.ARCount++
+
+	// ARCOUNT
+	msg.Raw[10] = byte(msg.Header.ARCount >> 8)
+	msg.Raw[11] = byte(msg.Header.ARCount)
+
+	return nil
+}
+
+// CompareUDPSize returns the EDNS UDP payload size advertised by upstreamReq minus the one advertised
+// by clientReq, a negative result means the upstream request was downgraded. A request without a
+// valid OPT record is considered to advertise 512 bytes.
+func CompareUDPSize(clientReq, upstreamReq *Message) int {
+	return int(udpSize(upstreamReq)) - int(udpSize(clientReq))
+}
+
+// udpSize returns the EDNS UDP payload size advertised by msg, or 512 if it has no valid OPT record.
+func udpSize(msg *Message) uint16 {
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		return 512
+	}
+	return uint16(r.Class)
+}
+
+// EDNS0 returns the UDP payload size, the upper 8 bits of the extended RCODE, the EDNS version, the DO bit
+// and the raw options of the OPT record in the additional section of msg. The options reference msg.Raw.
+// It returns ok=false if msg has no OPT record or the OPT record is invalid.
+func (msg *Message) EDNS0() (udpSize uint16, extRcode uint8, version uint8, doBit bool, options []byte, ok bool) {
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		return
+	}
+	return uint16(r.Class), uint8(r.TTL >> 24), uint8(r.TTL >> 16), r.TTL&0x8000 != 0, r.Data, true
+}
+
+// optRecord returns the OPT pseudo record in the additional section of msg and its offset in msg.Raw,
+// the offset is -1 if msg has no OPT record. As RFC 6891 requires, an OPT record owned by a name
+// other than root or a second OPT record is reported as ErrInvalidOPT.
+func (msg *Message) optRecord() (r MessageRecord, off int, err error) {
+	off = -1
+	n := int(msg.Header.ANCount) + int(msg.Header.NSCount)
+	err = msg.walk(func(i, o, rdata, end int) bool {
+		if i < n || Type(msg.Raw[rdata-10])<<8|Type(msg.Raw[rdata-9]) != TypeOPT {
+			return true
+		}
+		if off >= 0 || rdata-10 != o+1 {
+			off = -2
+			return false
+		}
+		r, off = msg.record(o, rdata, end), o
+		return true
+	})
+	if err == nil && off == -2 {
+		err = ErrInvalidOPT
+	}
+	if err != nil {
+		off = -1
+	}
+	return
+}
+
+// UpsertEDNS0Option replaces the option of code in the OPT record of msg with data, or appends
+// the option if it is absent. Other options are preserved, and Raw is updated.
+func (msg *Message) UpsertEDNS0Option(code uint16, data []byte) error {
+	r, off, err := msg.optRecord()
+	if err != nil {
+		return err
+	}
+	if off < 0 {
+		return ErrInvalidOPT
+	}
+
+	options := make([]byte, 0, len(r.Data)+4+len(data))
+	replaced := false
+	err = edns0Options(r.Data, func(c uint16, value []byte) bool {
+		if c == code {
+			if replaced {
+				return true
+			}
+			replaced, value = true, data
+		}
+		options = append(options, byte(c>>8), byte(c), byte(len(value)>>8), byte(len(value)))
+		options = append(options, value...)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if !replaced {
+		options = append(options, byte(code>>8), byte(code), byte(len(data)>>8), byte(len(data)))
+		options = append(options, data...)
+	}
+	if len(options) > 0xffff {
+		return ErrInvalidOPT
+	}
+
+	// OPT owner is root, so RDATA starts after 1 + 10 bytes
+	rdata := off + 11
+	length := len(options)
+	options = append(options, msg.Raw[rdata+len(r.Data):]...)
+	msg.Raw = append(msg.Raw[:rdata], options...)
+
+	// RDLENGTH
+	msg.Raw[rdata-2] = byte(length >> 8)
+	msg.Raw[rdata-1] = byte(length)
+
+	return nil
+}
+
+// EDNS0OptionCodes appends the codes of the options in the OPT record of msg to dst in order, without
+// decoding the option data. Nothing is appended if msg has no OPT record.
+func (msg *Message) EDNS0OptionCodes(dst []uint16) ([]uint16, error) {
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		return dst, err
+	}
+	err = edns0Options(r.Data, func(code uint16, value []byte) bool {
+		dst = append(dst, code)
+		return true
+	})
+	return dst, err
+}
+
+// EDNS0 option codes of the DNSSEC algorithms understood by a client, see RFC 6975.
+const (
+	edns0DAU uint16 = 5
+	edns0DHU uint16 = 6
+	edns0N3U uint16 = 7
+)
+
+// AlgorithmsUnderstood returns the lists of DNSSEC signing, DS hash and NSEC3 hash algorithm numbers
+// signaled by the DAU, DHU and N3U options of RFC 6975 in the OPT record of msg. ok is false if msg has
+// no valid OPT record, and a list is nil if its option is absent. The lists refer to Raw.
+func (msg *Message) AlgorithmsUnderstood() (dau, dhu, n3u []byte, ok bool) {
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		return
+	}
+	err = edns0Options(r.Data, func(code uint16, value []byte) bool {
+		switch code {
+		case edns0DAU:
+			dau = value
+		case edns0DHU:
+			dhu = value
+		case edns0N3U:
+			n3u = value
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, nil, false
+	}
+	return dau, dhu, n3u, true
+}
+
+// SetDAU sets the DAU option of RFC 6975 in the OPT record of msg to the DNSSEC signing algorithm numbers algs.
+func (msg *Message) SetDAU(algs []byte) error {
+	return msg.UpsertEDNS0Option(edns0DAU, algs)
+}
+
+// SetDHU sets the DHU option of RFC 6975 in the OPT record of msg to the DS hash algorithm numbers algs.
+func (msg *Message) SetDHU(algs []byte) error {
+	return msg.UpsertEDNS0Option(edns0DHU, algs)
+}
+
+// SetN3U sets the N3U option of RFC 6975 in the OPT record of msg to the NSEC3 hash algorithm numbers algs.
+func (msg *Message) SetN3U(algs []byte) error {
+	return msg.UpsertEDNS0Option(edns0N3U, algs)
+}
+
+// EDE represents an Extended DNS Error option of RFC 8914, e.g. InfoCode 6 is DNSSEC Bogus.
+type EDE struct {
+	InfoCode  uint16
+	ExtraText string
+}
+
+// edns0EDE is the EDNS0 option code of Extended DNS Errors.
+const edns0EDE uint16 = 15
+
+// ExtendedErrors returns the Extended DNS Errors of RFC 8914 in the OPT record of msg, in order. ok is false
+// if msg has no valid OPT record or no EDE option. EDE options shorter than the INFO-CODE are skipped, and
+// the options after a truncated option are ignored.
+func (msg *Message) ExtendedErrors() (edes []EDE, ok bool) {
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		return
+	}
+	_ = edns0Options(r.Data, func(code uint16, value []byte) bool {
+		if code == edns0EDE && len(value) >= 2 {
+			edes = append(edes, EDE{
+				InfoCode:  uint16(value[0])<<8 | uint16(value[1]),
+				ExtraText: string(value[2:]),
+			})
+		}
+		return true
+	})
+	return edes, len(edes) != 0
+}
+
+// edns0Cookie is the EDNS0 option code of DNS Cookies.
+const edns0Cookie uint16 = 10
+
+// SetEDNS0Cookie sets the COOKIE option of RFC 7873 in the OPT record of msg to the client cookie followed by
+// the server cookie, which is empty or 8 to 32 bytes. msg must have an OPT record, e.g. added by SetEDNS0.
+func (msg *Message) SetEDNS0Cookie(client [8]byte, server []byte) error {
+	if n := len(server); n != 0 && (n < 8 || n > 32) {
+		return ErrInvalidOPT
+	}
+	return msg.UpsertEDNS0Option(edns0Cookie, append(client[:], server...))
+}
+
+// EDNS0Cookie returns the client and server cookies of the COOKIE option of RFC 7873 in the OPT record of msg,
+// the server cookie is empty if only the client cookie is present and refers to Raw. ok is false if msg has
+// no valid OPT record, no COOKIE option, or the option has an invalid length.
+func (msg *Message) EDNS0Cookie() (client [8]byte, server []byte, ok bool) {
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		return
+	}
+	_ = edns0Options(r.Data, func(code uint16, value []byte) bool {
+		if code != edns0Cookie {
+			return true
+		}
+		if n := len(value); n == 8 || (n >= 16 && n <= 40) {
+			copy(client[:], value)
+			server, ok = value[8:], true
+		}
+		return false
+	})
+	return
+}
+
+// edns0Subnet is the EDNS0 option code of Client Subnet.
+const edns0Subnet uint16 = 8
+
+// SetEDNS0Subnet sets the ECS option of RFC 7871 in the OPT record of msg to prefix with SCOPE PREFIX-LENGTH 0,
+// the address is truncated to the prefix length. msg must have an OPT record, e.g. added by SetEDNS0.
+func (msg *Message) SetEDNS0Subnet(prefix netip.Prefix) error {
+	if !prefix.IsValid() {
+		return ErrInvalidOPT
+	}
+	prefix = prefix.Masked()
+
+	family := byte(1)
+	if prefix.Addr().Is6() {
+		family = 2
+	}
+	bits := prefix.Bits()
+	addr := prefix.Addr().AsSlice()
+
+	// FAMILY, SOURCE PREFIX-LENGTH, SCOPE PREFIX-LENGTH, ADDRESS
+	data := append([]byte{0, family, byte(bits), 0}, addr[:(bits+7)/8]...)
+
+	return msg.UpsertEDNS0Option(edns0Subnet, data)
+}
+
+// EDNS0Subnet returns the source prefix and the SCOPE PREFIX-LENGTH of the ECS option of RFC 7871 in the OPT
+// record of msg. ok is false if msg has no valid OPT record, no ECS option, or the option is malformed, i.e. the
+// family is unknown, the address is not truncated to the source prefix length or has bits set beyond it.
+func (msg *Message) EDNS0Subnet() (prefix netip.Prefix, scope uint8, ok bool) {
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		return
+	}
+	_ = edns0Options(r.Data, func(code uint16, value []byte) bool {
+		if code != edns0Subnet {
+			return true
+		}
+		if len(value) < 4 {
+			return false
+		}
+		var addr [16]byte
+		size := 0
+		switch uint16(value[0])<<8 | uint16(value[1]) {
+		case 1:
+			size = 4
+		case 2:
+			size = 16
+		default:
+			return false
+		}
+		bits := int(value[2])
+		if bits > size*8 || int(value[3]) > size*8 || len(value)-4 != (bits+7)/8 {
+			return false
+		}
+		copy(addr[:], value[4:])
+		var ip netip.Addr
+		if size == 4 {
+			ip = netip.AddrFrom4([4]byte(addr[:4]))
+		} else {
+			ip = netip.AddrFrom16(addr)
+		}
+		if prefix = netip.PrefixFrom(ip, bits); prefix.Masked() != prefix {
+			prefix = netip.Prefix{}
+			return false
+		}
+		scope, ok = value[3], true
+		return false
+	})
+	return
+}
+
+// RemoveOPT removes the OPT record from the additional section of msg and updates ARCount and Raw,
+// so that the response can be returned to a client which did not send EDNS. It is a no-op if msg has
+// no OPT record.
+func (msg *Message) RemoveOPT() error {
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		return err
+	}
+
+	// OPT owner is root, so RDATA starts after 1 + 10 bytes
+	msg.Raw = append(msg.Raw[:off], msg.Raw[off+11+len(r.Data):]...)
+
+	msg.Header.ARCount--
+
+	// ARCOUNT
+	msg.Raw[10] = byte(msg.Header.ARCount >> 8)
+	msg.Raw[11] = byte(msg.Header.ARCount)
+
+	return nil
+}
+
+// edns0Options calls f for each option in the OPT RDATA data until f returns false.
+func edns0Options(data []byte, f func(code uint16, value []byte) bool) error {
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return ErrInvalidOPT
+		}
+		code := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		if 4+length > len(data) {
+			return ErrInvalidOPT
+		}
+		if !f(code, data[4:4+length]) {
+			break
+		}
+		data = data[4+length:]
+	}
+	return nil
+}
+
+// ComputeServerCookie returns an 8 bytes DNS server cookie of RFC 7873 for the client cookie and address,
+// it is the HMAC-SHA256 of the client cookie followed by the client address, keyed by secret and truncated to 8 bytes.
+func ComputeServerCookie(secret []byte, clientCookie [8]byte, clientIP netip.Addr) []byte {
+	ip := clientIP.Unmap().As16()
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(clientCookie[:])
+	if clientIP.Unmap().Is4() {
+		mac.Write(ip[12:])
+	} else {
+		mac.Write(ip[:])
+	}
+	return mac.Sum(nil)[:8]
+}
+
+// VerifyServerCookie reports whether serverCookie is the one computed by ComputeServerCookie for the client cookie and address.
+func VerifyServerCookie(secret []byte, clientCookie [8]byte, clientIP netip.Addr, serverCookie []byte) bool {
+	return hmac.Equal(ComputeServerCookie(secret, clientCookie, clientIP), serverCookie)
+}
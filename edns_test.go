@@ -0,0 +1,647 @@
+package fastdns
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func mockEDNSMessage(additionals ...[]byte) *Message {
+	msg := AcquireMessage()
+	// pooled messages keep the flags of their last use
+	msg.Header.Flags = 0
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	for _, rr := range additionals {
+		msg.Raw = append(msg.Raw, rr...)
+	}
+	msg.Header.ARCount = uint16(len(additionals))
+	msg.Raw[11] = byte(len(additionals))
+	return msg
+}
+
+func TestOPTRecordOwner(t *testing.T) {
+	var cases = []struct {
+		OPT   []byte
+		Error error
+	}{
+		{
+			// OPT, owner ".", UDP 1232, RDLENGTH 0
+			[]byte("\x00\x00\x29\x04\xd0\x00\x00\x00\x00\x00\x00"),
+			nil,
+		},
+		{
+			// OPT, owner "bogus.", UDP 1232, RDLENGTH 0
+			[]byte("\x05bogus\x00\x00\x29\x04\xd0\x00\x00\x00\x00\x00\x00"),
+			ErrInvalidOPT,
+		},
+		{
+			// OPT, owner pointer to question name, UDP 1232, RDLENGTH 0
+			[]byte("\xc0\x0c\x00\x29\x04\xd0\x00\x00\x00\x00\x00\x00"),
+			ErrInvalidOPT,
+		},
+	}
+
+	for _, c := range cases {
+		msg := mockEDNSMessage(c.OPT)
+		r, off, err := msg.optRecord()
+		if err != c.Error {
+			t.Errorf("optRecord(%x) error got=%+v want=%+v", c.OPT, err, c.Error)
+		}
+		if err == nil && (off < 0 || r.Type != TypeOPT || r.Class != 1232) {
+			t.Errorf("optRecord(%x) got=%+v offset=%d", c.OPT, r, off)
+		}
+		ReleaseMessage(msg)
+	}
+}
+
+func TestAdditionalRecords(t *testing.T) {
+	msg := mockEDNSMessage(
+		[]byte("\x02ns\xc0\x0c\x00\x01\x00\x01\x00\x00\x01\x2c\x00\x04\x01\x01\x01\x01"),
+		[]byte("\x00\x00\x29\x04\xd0\x00\x00\x00\x00\x00\x00"),
+	)
+	defer ReleaseMessage(msg)
+
+	var types []Type
+	for r := range msg.AdditionalRecords {
+		types = append(types, r.Type)
+	}
+	if len(types) != 2 || types[0] != TypeA || types[1] != TypeOPT {
+		t.Errorf("AdditionalRecords got=%v want=[A OPT]", types)
+	}
+}
+
+func TestUpsertEDNS0Option(t *testing.T) {
+	var cases = []struct {
+		Code    uint16
+		Data    string
+		Options string
+	}{
+		{
+			// replaces the cookie, keeps the unknown option
+			10, "\x11\x22\x33\x44\x55\x66\x77\x88",
+			"\x00\x0a\x00\x08\x11\x22\x33\x44\x55\x66\x77\x88\xfd\xe9\x00\x02\xab\xcd",
+		},
+		{
+			// appends the client subnet
+			8, "\x00\x01\x18\x00\xc0\x00\x02",
+			"\x00\x0a\x00\x08\x01\x02\x03\x04\x05\x06\x07\x08\xfd\xe9\x00\x02\xab\xcd\x00\x08\x00\x07\x00\x01\x18\x00\xc0\x00\x02",
+		},
+	}
+
+	for _, c := range cases {
+		msg := mockEDNSMessage(
+			// OPT, UDP 1232, cookie and an unknown option 65001
+			[]byte("\x00\x00\x29\x04\xd0\x00\x00\x00\x00\x00\x12\x00\x0a\x00\x08\x01\x02\x03\x04\x05\x06\x07\x08\xfd\xe9\x00\x02\xab\xcd"),
+			// A record after OPT
+			[]byte("\x02ns\xc0\x0c\x00\x01\x00\x01\x00\x00\x01\x2c\x00\x04\x01\x01\x01\x01"),
+		)
+
+		err := msg.UpsertEDNS0Option(c.Code, []byte(c.Data))
+		if err != nil {
+			t.Errorf("UpsertEDNS0Option(%d) error: %+v", c.Code, err)
+		}
+
+		r, _, err := msg.optRecord()
+		if err != nil {
+			t.Errorf("UpsertEDNS0Option(%d) result error: %+v", c.Code, err)
+		}
+		if got, want := string(r.Data), c.Options; got != want {
+			t.Errorf("UpsertEDNS0Option(%d) got=%x want=%x", c.Code, got, want)
+		}
+
+		var n int
+		for r := range msg.AdditionalRecords {
+			if r.Type == TypeA && string(r.Data) != "\x01\x01\x01\x01" {
+				t.Errorf("UpsertEDNS0Option(%d) corrupted the following record: %x", c.Code, r.Data)
+			}
+			n++
+		}
+		if n != 2 {
+			t.Errorf("UpsertEDNS0Option(%d) additional records got=%d want=2", c.Code, n)
+		}
+
+		ReleaseMessage(msg)
+	}
+}
+
+func TestServerCookie(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	client := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	ip1, ip2 := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")
+
+	cookie := ComputeServerCookie(secret, client, ip1)
+	if len(cookie) != 8 {
+		t.Errorf("ComputeServerCookie length got=%d want=8", len(cookie))
+	}
+	if got := ComputeServerCookie(secret, client, ip1); !bytes.Equal(got, cookie) {
+		t.Errorf("ComputeServerCookie shall be deterministic got=%x want=%x", got, cookie)
+	}
+	if got := ComputeServerCookie(secret, client, netip.MustParseAddr("::ffff:192.0.2.1")); !bytes.Equal(got, cookie) {
+		t.Errorf("ComputeServerCookie shall unmap IPv4-mapped address got=%x want=%x", got, cookie)
+	}
+	if got := ComputeServerCookie(secret, client, ip2); bytes.Equal(got, cookie) {
+		t.Errorf("ComputeServerCookie shall depend on client address got=%x", got)
+	}
+
+	if !VerifyServerCookie(secret, client, ip1, cookie) {
+		t.Errorf("VerifyServerCookie(%s, %x) shall be true", ip1, cookie)
+	}
+	if VerifyServerCookie(secret, client, ip2, cookie) {
+		t.Errorf("VerifyServerCookie(%s, %x) shall be false", ip2, cookie)
+	}
+}
+
+func TestClampUDPSize(t *testing.T) {
+	var cases = []struct {
+		Requested uint16
+		Size      uint16
+	}{
+		{0, 512},
+		{512, 512},
+		{1232, 1232},
+		{4096, 1232},
+	}
+
+	for _, c := range cases {
+		if got, want := ClampUDPSize(c.Requested), c.Size; got != want {
+			t.Errorf("ClampUDPSize(%d) got=%d want=%d", c.Requested, got, want)
+		}
+	}
+}
+
+func TestCompareUDPSize(t *testing.T) {
+	opt := func(size uint16) []byte {
+		return []byte{0x00, 0x00, 0x29, byte(size >> 8), byte(size), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	}
+
+	var cases = []struct {
+		Client   []byte
+		Upstream []byte
+		Diff     int
+	}{
+		{opt(1232), opt(1232), 0},
+		{opt(4096), opt(1232), -2864},
+		{opt(1232), nil, -720},
+		{nil, opt(1232), 720},
+		{nil, nil, 0},
+	}
+
+	for _, c := range cases {
+		var client, upstream [][]byte
+		if c.Client != nil {
+			client = append(client, c.Client)
+		}
+		if c.Upstream != nil {
+			upstream = append(upstream, c.Upstream)
+		}
+		clientReq, upstreamReq := mockEDNSMessage(client...), mockEDNSMessage(upstream...)
+		if got, want := CompareUDPSize(clientReq, upstreamReq), c.Diff; got != want {
+			t.Errorf("CompareUDPSize(%x, %x) got=%d want=%d", c.Client, c.Upstream, got, want)
+		}
+		ReleaseMessage(clientReq)
+		ReleaseMessage(upstreamReq)
+	}
+}
+
+func TestAppendOPTRecord(t *testing.T) {
+	options := []EDNS0Option{
+		{Code: 3, Data: []byte("ns1")},
+		{Code: 12, Data: make([]byte, 8)},
+		{Code: 15, Data: []byte{0x00, 0x12}},
+	}
+
+	msg := mockEDNSMessage(AppendOPTRecord(nil, 1232, 1, 0, true, options))
+	defer ReleaseMessage(msg)
+
+	r, off, err := msg.optRecord()
+	if err != nil || off < 0 {
+		t.Fatalf("optRecord got off=%d error=%+v", off, err)
+	}
+	if r.Class != 1232 || r.TTL != 0x01008000 {
+		t.Errorf("AppendOPTRecord got udp size=%d ttl=%#x", r.Class, r.TTL)
+	}
+
+	var got []EDNS0Option
+	err = edns0Options(r.Data, func(code uint16, value []byte) bool {
+		got = append(got, EDNS0Option{Code: code, Data: value})
+		return true
+	})
+	if err != nil {
+		t.Fatalf("edns0Options error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, options) {
+		t.Errorf("AppendOPTRecord got options=%+v want=%+v", got, options)
+	}
+}
+
+func TestRemoveOPT(t *testing.T) {
+	// ns1.example.org A 1.2.4.8
+	glue := []byte{0x03, 'n', 's', '1', 0xc0, 0x0c, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x04, 1, 2, 4, 8}
+	opt := AppendOPTRecord(nil, 1232, 0, 0, false, []EDNS0Option{{Code: 3, Data: []byte("ns1")}})
+
+	msg := mockEDNSMessage(glue, opt)
+	defer ReleaseMessage(msg)
+	want := mockEDNSMessage(glue)
+	defer ReleaseMessage(want)
+
+	if err := msg.RemoveOPT(); err != nil {
+		t.Fatalf("RemoveOPT error: %+v", err)
+	}
+	if !bytes.Equal(msg.Raw[2:], want.Raw[2:]) || msg.Header.ARCount != 1 {
+		t.Errorf("RemoveOPT got=%x want=%x", msg.Raw, want.Raw)
+	}
+
+	if err := msg.RemoveOPT(); err != nil || !bytes.Equal(msg.Raw[2:], want.Raw[2:]) {
+		t.Errorf("RemoveOPT without OPT shall be a no-op, got=%x error=%+v", msg.Raw, err)
+	}
+}
+
+func TestSetFormErrWithOPT(t *testing.T) {
+	// an OPT record owned by a non-root name
+	req := mockEDNSMessage([]byte{0x01, 'a', 0x00, 0x00, 0x29, 0x04, 0xd0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
+	defer ReleaseMessage(req)
+
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	resp.SetFormErrWithOPT(req, 1232)
+
+	if got := resp.Header.Flags.Rcode(); got != RcodeFormErr {
+		t.Errorf("SetFormErrWithOPT got rcode=%s want=%s", got, RcodeFormErr)
+	}
+	if resp.Header.ID != req.Header.ID || resp.Header.QDCount != 0 || resp.Header.ARCount != 1 {
+		t.Errorf("SetFormErrWithOPT got header=%+v", resp.Header)
+	}
+	// RD is copied and RA is left to the caller
+	if resp.Header.Flags.RD() != req.Header.Flags.RD() || resp.Header.Flags.RA() != 0 || resp.Raw[3]&0b10000000 != 0 {
+		t.Errorf("SetFormErrWithOPT got flags=%016b want RD=%d RA=0", resp.Header.Flags, req.Header.Flags.RD())
+	}
+
+	r, off, err := resp.optRecord()
+	if err != nil || off < 0 {
+		t.Fatalf("SetFormErrWithOPT shall have an OPT record, got off=%d error=%+v", off, err)
+	}
+	if r.Class != 1232 || len(r.Data) != 0 {
+		t.Errorf("SetFormErrWithOPT got udp size=%d options=%x", r.Class, r.Data)
+	}
+}
+
+func TestSetEDNS0(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	question := msg.Bytes()
+
+	for _, c := range []struct {
+		UDPSize uint16
+		DO      bool
+	}{
+		{1232, true},
+		{4096, false},
+	} {
+		if err := msg.SetEDNS0(c.UDPSize, c.DO); err != nil {
+			t.Fatalf("SetEDNS0(%d, %v) error: %+v", c.UDPSize, c.DO, err)
+		}
+		if !bytes.Equal(msg.Raw[12:len(question)], question[12:]) {
+			t.Errorf("SetEDNS0(%d, %v) changed the question: %x", c.UDPSize, c.DO, msg.Raw)
+		}
+
+		got := new(Message)
+		if err := ParseAndValidate(got, msg.Raw); err != nil {
+			t.Fatalf("SetEDNS0(%d, %v) %x error: %+v", c.UDPSize, c.DO, msg.Raw, err)
+		}
+		if got.Header.ARCount != 1 {
+			t.Errorf("SetEDNS0(%d, %v) ARCount got=%d want=1", c.UDPSize, c.DO, got.Header.ARCount)
+		}
+		r, off, err := got.optRecord()
+		if err != nil || off != len(question) {
+			t.Fatalf("SetEDNS0(%d, %v) OPT offset got=%d err=%+v", c.UDPSize, c.DO, off, err)
+		}
+		if r.Class != Class(c.UDPSize) || (r.TTL&0x8000 != 0) != c.DO || len(r.Data) != 0 {
+			t.Errorf("SetEDNS0(%d, %v) OPT got=%+v", c.UDPSize, c.DO, r)
+		}
+	}
+}
+
+func TestMessageEDNS0(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 0)
+	if _, _, _, _, _, ok := msg.EDNS0(); ok {
+		t.Errorf("EDNS0() without OPT got ok=true")
+	}
+
+	// BADVERS is 16, its upper 8 bits are 1
+	msg.Raw = AppendOPTRecord(msg.Raw, 1232, 1, 0, true, []EDNS0Option{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}})
+	msg.Header.ARCount = 1
+	msg.Raw[11] = 1
+
+	udpSize, extRcode, version, doBit, options, ok := msg.EDNS0()
+	if !ok || udpSize != 1232 || extRcode != 1 || version != 0 || !doBit {
+		t.Errorf("EDNS0() got=%d %d %d %v %v", udpSize, extRcode, version, doBit, ok)
+	}
+	if got, want := hex.EncodeToString(options), "000a00080102030405060708"; got != want {
+		t.Errorf("EDNS0() options got=%s want=%s", got, want)
+	}
+	if rcode := Rcode(extRcode)<<4 | msg.Header.Flags.Rcode(); rcode != RcodeBADVERS {
+		t.Errorf("EDNS0() extended rcode got=%s want=%s", rcode, RcodeBADVERS)
+	}
+
+	// a second OPT record is invalid
+	msg.Raw = AppendOPTRecord(msg.Raw, 512, 0, 0, false, nil)
+	msg.Header.ARCount = 2
+	msg.Raw[11] = 2
+	if _, _, _, _, _, ok := msg.EDNS0(); ok {
+		t.Errorf("EDNS0() with two OPT records got ok=true")
+	}
+}
+
+func TestMessageExtendedErrors(t *testing.T) {
+	var cases = []struct {
+		Options []EDNS0Option
+		Trailer []byte
+		EDEs    []EDE
+	}{
+		{
+			Options: []EDNS0Option{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}},
+		},
+		{
+			Options: []EDNS0Option{
+				{Code: 15, Data: []byte("\x00\x06signature expired")},
+				{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+				{Code: 15, Data: []byte("\x00\x17")},
+			},
+			EDEs: []EDE{{6, "signature expired"}, {23, ""}},
+		},
+		{
+			// an option without INFO-CODE is skipped
+			Options: []EDNS0Option{{Code: 15, Data: []byte{0}}, {Code: 15, Data: []byte("\x00\x12blocked")}},
+			EDEs:    []EDE{{18, "blocked"}},
+		},
+		{
+			// the truncated option is ignored
+			Options: []EDNS0Option{{Code: 15, Data: []byte("\x00\x16")}},
+			Trailer: []byte{0x00, 0x0f, 0x00, 0x08, 0x00},
+			EDEs:    []EDE{{22, ""}},
+		},
+	}
+
+	for _, c := range cases {
+		msg := new(Message)
+		msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+		msg.SetResponseHeader(RcodeNoError, 0)
+		opt := len(msg.Raw)
+		msg.Raw = AppendOPTRecord(msg.Raw, 1232, 0, 0, false, c.Options)
+		if len(c.Trailer) != 0 {
+			msg.Raw = append(msg.Raw, c.Trailer...)
+			// RDLENGTH
+			rdlen := len(msg.Raw) - opt - 11
+			msg.Raw[opt+9], msg.Raw[opt+10] = byte(rdlen>>8), byte(rdlen)
+		}
+		msg.Header.ARCount = 1
+		msg.Raw[11] = 1
+
+		edes, ok := msg.ExtendedErrors()
+		if ok != (len(c.EDEs) != 0) || !reflect.DeepEqual(edes, c.EDEs) {
+			t.Errorf("ExtendedErrors(%x) got=%+v %v want=%+v", msg.Raw, edes, ok, c.EDEs)
+		}
+	}
+
+	if _, ok := new(Message).ExtendedErrors(); ok {
+		t.Errorf("ExtendedErrors() of empty message got ok=true")
+	}
+}
+
+func TestEDNS0Cookie(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	client := [8]byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if err := msg.SetEDNS0Cookie(client, nil); err != ErrInvalidOPT {
+		t.Errorf("SetEDNS0Cookie() without OPT error got=%+v want=%+v", err, ErrInvalidOPT)
+	}
+	if err := msg.SetEDNS0(1232, false); err != nil {
+		t.Fatalf("SetEDNS0() error: %+v", err)
+	}
+	if _, _, ok := msg.EDNS0Cookie(); ok {
+		t.Errorf("EDNS0Cookie() without COOKIE got ok=true")
+	}
+
+	for _, server := range [][]byte{
+		{},
+		ComputeServerCookie([]byte("secret"), client, netip.MustParseAddr("192.0.2.1")),
+		bytes.Repeat([]byte{0xaa}, 32),
+	} {
+		if err := msg.SetEDNS0Cookie(client, server); err != nil {
+			t.Fatalf("SetEDNS0Cookie(%x) error: %+v", server, err)
+		}
+		if err := ParseAndValidate(new(Message), msg.Raw); err != nil {
+			t.Fatalf("SetEDNS0Cookie(%x) %x error: %+v", server, msg.Raw, err)
+		}
+		codes, _ := msg.EDNS0OptionCodes(nil)
+		if !reflect.DeepEqual(codes, []uint16{10}) {
+			t.Errorf("SetEDNS0Cookie(%x) option codes got=%v want=[10]", server, codes)
+		}
+		gotClient, gotServer, ok := msg.EDNS0Cookie()
+		if !ok || gotClient != client || !bytes.Equal(gotServer, server) {
+			t.Errorf("EDNS0Cookie() got=%x %x %v want=%x %x", gotClient, gotServer, ok, client, server)
+		}
+	}
+
+	for _, server := range [][]byte{make([]byte, 7), make([]byte, 33)} {
+		if err := msg.SetEDNS0Cookie(client, server); err != ErrInvalidOPT {
+			t.Errorf("SetEDNS0Cookie(%x) error got=%+v want=%+v", server, err, ErrInvalidOPT)
+		}
+	}
+
+	// a malformed option length
+	if err := msg.UpsertEDNS0Option(10, make([]byte, 12)); err != nil {
+		t.Fatalf("UpsertEDNS0Option() error: %+v", err)
+	}
+	if _, _, ok := msg.EDNS0Cookie(); ok {
+		t.Errorf("EDNS0Cookie() with 12 bytes option got ok=true")
+	}
+}
+
+func TestEDNS0Subnet(t *testing.T) {
+	var cases = []struct {
+		Prefix string
+		Want   string
+		Option string
+	}{
+		{"192.0.2.77/24", "192.0.2.0/24", "0008000700011800c00002"},
+		{"2001:db8:1234:56ff::1/56", "2001:db8:1234:5600::/56", "0008000b0002380020010db8123456"},
+		{"198.51.100.1/0", "0.0.0.0/0", "0008000400010000"},
+		{"198.51.100.1/32", "198.51.100.1/32", "0008000800012000c6336401"},
+	}
+
+	for _, c := range cases {
+		msg := new(Message)
+		msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+		if err := msg.SetEDNS0(1232, false); err != nil {
+			t.Fatalf("SetEDNS0() error: %+v", err)
+		}
+		if err := msg.SetEDNS0Subnet(netip.MustParsePrefix(c.Prefix)); err != nil {
+			t.Fatalf("SetEDNS0Subnet(%s) error: %+v", c.Prefix, err)
+		}
+		if _, _, _, _, options, _ := msg.EDNS0(); hex.EncodeToString(options) != c.Option {
+			t.Errorf("SetEDNS0Subnet(%s) option got=%x want=%s", c.Prefix, options, c.Option)
+		}
+		prefix, scope, ok := msg.EDNS0Subnet()
+		if !ok || prefix.String() != c.Want || scope != 0 {
+			t.Errorf("EDNS0Subnet() got=%s %d %v want=%s 0", prefix, scope, ok, c.Want)
+		}
+	}
+
+	// responses carry the SCOPE PREFIX-LENGTH
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.Raw = AppendOPTRecord(msg.Raw, 1232, 0, 0, false, []EDNS0Option{{Code: 8, Data: []byte{0, 1, 24, 16, 192, 0, 2}}})
+	msg.Header.ARCount = 1
+	msg.Raw[11] = 1
+	if prefix, scope, ok := msg.EDNS0Subnet(); !ok || prefix != netip.MustParsePrefix("192.0.2.0/24") || scope != 16 {
+		t.Errorf("EDNS0Subnet() got=%s %d %v want=192.0.2.0/24 16", prefix, scope, ok)
+	}
+
+	for _, data := range [][]byte{
+		// unknown family
+		{0, 3, 24, 0, 192, 0, 2},
+		// address not truncated
+		{0, 1, 24, 0, 192, 0, 2, 0},
+		// bits set beyond the source prefix length
+		{0, 1, 23, 0, 192, 0, 3},
+		// source prefix length too long
+		{0, 1, 33, 0, 192, 0, 2, 1, 0},
+		// short option
+		{0, 1, 0},
+	} {
+		if err := msg.UpsertEDNS0Option(8, data); err != nil {
+			t.Fatalf("UpsertEDNS0Option(%x) error: %+v", data, err)
+		}
+		if prefix, _, ok := msg.EDNS0Subnet(); ok {
+			t.Errorf("EDNS0Subnet(%x) got=%s ok=true", data, prefix)
+		}
+	}
+
+	if err := msg.SetEDNS0Subnet(netip.Prefix{}); err != ErrInvalidOPT {
+		t.Errorf("SetEDNS0Subnet() of invalid prefix error got=%+v want=%+v", err, ErrInvalidOPT)
+	}
+}
+
+func TestAlgorithmsUnderstood(t *testing.T) {
+	msg := mockEDNSMessage()
+	if _, _, _, ok := msg.AlgorithmsUnderstood(); ok {
+		t.Errorf("AlgorithmsUnderstood() without OPT got ok=true want=false")
+	}
+	ReleaseMessage(msg)
+
+	msg = mockEDNSMessage(AppendOPTRecord(nil, 1232, 0, 0, true, nil))
+	defer ReleaseMessage(msg)
+
+	// RSASHA256, ECDSAP256SHA256 and ED25519
+	if err := msg.SetDAU([]byte{8, 13, 15}); err != nil {
+		t.Fatalf("SetDAU() error: %+v", err)
+	}
+	if err := msg.SetN3U([]byte{1}); err != nil {
+		t.Fatalf("SetN3U() error: %+v", err)
+	}
+
+	dau, dhu, n3u, ok := msg.AlgorithmsUnderstood()
+	if !ok {
+		t.Fatalf("AlgorithmsUnderstood() got ok=false want=true")
+	}
+	if !bytes.Equal(dau, []byte{8, 13, 15}) || dhu != nil || !bytes.Equal(n3u, []byte{1}) {
+		t.Errorf("AlgorithmsUnderstood() got=(%v, %v, %v) want=([8 13 15], [], [1])", dau, dhu, n3u)
+	}
+}
+
+func TestEDNS0OptionCodes(t *testing.T) {
+	msg := mockEDNSMessage(AppendOPTRecord(nil, 1232, 0, 0, false, []EDNS0Option{
+		// cookie
+		{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		// client subnet
+		{Code: 8, Data: []byte{0, 1, 24, 0, 192, 0, 2}},
+	}))
+	defer ReleaseMessage(msg)
+
+	codes, err := msg.EDNS0OptionCodes(make([]uint16, 0, 4))
+	if err != nil {
+		t.Fatalf("EDNS0OptionCodes() error: %+v", err)
+	}
+	if !reflect.DeepEqual(codes, []uint16{10, 8}) {
+		t.Errorf("EDNS0OptionCodes() got=%v want=[10 8]", codes)
+	}
+
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	if codes, err := msg.EDNS0OptionCodes(nil); err != nil || len(codes) != 0 {
+		t.Errorf("EDNS0OptionCodes() without OPT got=(%v, %+v) want=([], nil)", codes, err)
+	}
+}
+
+func TestSetQuestionFromRequest(t *testing.T) {
+	req := mockEDNSMessage(AppendOPTRecord(nil, 1232, 0, 0, true, []EDNS0Option{
+		// client subnet
+		{Code: 8, Data: []byte{0, 1, 24, 0, 192, 0, 2}},
+		// cookie
+		{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+	}))
+	defer ReleaseMessage(req)
+
+	msg := new(Message)
+	msg.SetQuestionFromRequest(req)
+
+	if msg.Header.ID == req.Header.ID {
+		t.Errorf("SetQuestionFromRequest() kept the ID %d", msg.Header.ID)
+	}
+	if !SameQuestion(msg, req) || msg.Header.Flags.QR() != 0 || msg.Header.Flags.RD() != 1 {
+		t.Errorf("SetQuestionFromRequest() got=%+v", msg)
+	}
+
+	got := new(Message)
+	if err := ParseAndValidate(got, msg.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", msg.Raw, err)
+	}
+	if got.Header.ID != msg.Header.ID || got.Header.ARCount != 1 {
+		t.Errorf("SetQuestionFromRequest() header got=%+v", got.Header)
+	}
+
+	want, _, _ := req.optRecord()
+	r, _, err := got.optRecord()
+	if err != nil || !reflect.DeepEqual(r, want) {
+		t.Errorf("SetQuestionFromRequest() OPT got=(%+v, %+v) want=%+v", r, err, want)
+	}
+}
+
+func TestOPTRecordAfterAdditional(t *testing.T) {
+	msg := mockEDNSMessage(
+		// A record before OPT
+		[]byte("\x02ns\xc0\x0c\x00\x01\x00\x01\x00\x00\x01\x2c\x00\x04\x01\x01\x01\x01"),
+		AppendOPTRecord(nil, 1232, 0, 0, true, []EDNS0Option{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}}),
+	)
+	defer ReleaseMessage(msg)
+
+	if codes, err := msg.EDNS0OptionCodes(nil); err != nil || !reflect.DeepEqual(codes, []uint16{10}) {
+		t.Errorf("EDNS0OptionCodes() got=(%v, %+v) want=([10], nil)", codes, err)
+	}
+	if got := udpSize(msg); got != 1232 {
+		t.Errorf("udpSize() got=%d want=1232", got)
+	}
+
+	if err := msg.SetDAU([]byte{13}); err != nil {
+		t.Fatalf("SetDAU() error: %+v", err)
+	}
+	if dau, _, _, ok := msg.AlgorithmsUnderstood(); !ok || !bytes.Equal(dau, []byte{13}) {
+		t.Errorf("AlgorithmsUnderstood() got=(%v, %v) want=([13], true)", dau, ok)
+	}
+
+	if err := msg.RemoveOPT(); err != nil {
+		t.Fatalf("RemoveOPT() error: %+v", err)
+	}
+	var types []Type
+	for r := range msg.AdditionalRecords {
+		types = append(types, r.Type)
+	}
+	if !reflect.DeepEqual(types, []Type{TypeA}) {
+		t.Errorf("RemoveOPT() additional records got=%v want=[A]", types)
+	}
+}
@@ -78,3 +78,26 @@ func TXT(rw ResponseWriter, req *Message, ttl uint32, txt string) {
 	req.Raw = AppendTXTRecord(req.Raw, req, ttl, txt)
 	_, _ = rw.Write(req.Raw)
 }
+
+// BuildTruncatedPair returns a pair of responses to req as a fixture for testing TCP fallback of clients.
+// The udp response has TC=1 and no answers, the tcp response is complete with the host records of addrs.
+func BuildTruncatedPair(req *Message, addrs []netip.Addr, ttl uint32) (udp []byte, tcp []byte) {
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	if ParseMessage(resp, req.Raw, true) != nil {
+		return
+	}
+
+	resp.SetResponseHeader(RcodeNoError, uint16(len(addrs)))
+	resp.Raw = AppendHOSTRecord(resp.Raw, resp, ttl, addrs)
+	tcp = append(tcp, resp.Raw...)
+
+	// TC = 1
+	resp.SetResponseHeader(RcodeNoError, 0)
+	resp.Header.Flags |= 0b0000001000000000
+	resp.Raw[2] = byte(resp.Header.Flags >> 8)
+	udp = append(udp, resp.Raw...)
+
+	return
+}
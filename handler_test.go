@@ -257,6 +257,22 @@ func TestHandlerTXT(t *testing.T) {
 	}
 }
 
+func TestBuildTruncatedPair(t *testing.T) {
+	req := new(Message)
+	req.SetRequestQuestion("example.org", TypeA, ClassINET)
+	req.Header.ID = 0x0002
+	req.Raw[0], req.Raw[1] = 0x00, 0x02
+
+	udp, tcp := BuildTruncatedPair(req, []netip.Addr{netip.AddrFrom4([4]byte{1, 1, 1, 1})}, 300)
+
+	if got, want := hex.EncodeToString(udp), "00028300000100000000000007"+"6578616d706c65036f72670000010001"; got != want {
+		t.Errorf("BuildTruncatedPair udp got=%s want=%s", got, want)
+	}
+	if got, want := hex.EncodeToString(tcp), "00028100000100010000000007"+"6578616d706c65036f72670000010001"+"c00c000100010000012c000401010101"; got != want {
+		t.Errorf("BuildTruncatedPair tcp got=%s want=%s", got, want)
+	}
+}
+
 type nilResponseWriter struct{}
 
 func (rw *nilResponseWriter) RemoteAddr() netip.AddrPort { return netip.AddrPort{} }
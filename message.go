@@ -1,8 +1,13 @@
 package fastdns
 
 import (
+	"bytes"
 	"errors"
+	"net/netip"
+	"slices"
+	"strconv"
 	"sync"
+	"time"
 )
 
 // Message represents an DNS request received by a server or to be sent by a client.
@@ -92,14 +97,51 @@ type Message struct {
 var (
 	// ErrInvalidHeader is returned when dns message does not have the expected header size.
 	ErrInvalidHeader = errors.New("dns message does not have the expected header size")
+	// ErrMultipleQuestions is returned by ParseSingleQuestion when dns message has more than one question, which should
+	// be answered with FORMERR. It wraps ErrInvalidHeader, so errors.Is(err, ErrInvalidHeader) also reports it.
+	ErrMultipleQuestions error = &multipleQuestionsError{}
 	// ErrInvalidQuestion is returned when dns message does not have the expected question size.
 	ErrInvalidQuestion = errors.New("dns message does not have the expected question size")
 	// ErrInvalidAnswer is returned when dns message does not have the expected answer size.
 	ErrInvalidAnswer = errors.New("dns message does not have the expected answer size")
+	// ErrInvalidName is returned when dns name is malformed or longer than 255 bytes.
+	ErrInvalidName = errors.New("dns name is malformed or longer than 255 bytes")
+	// ErrInvalidOPT is returned when dns message does not have a valid OPT record.
+	ErrInvalidOPT = errors.New("dns message does not have a valid OPT record")
+	// ErrInvalidPrefix is returned when a NAT64 prefix does not have a length defined by RFC 6052.
+	ErrInvalidPrefix = errors.New("nat64 prefix length must be one of 32, 40, 48, 56, 64 or 96")
 )
 
+type multipleQuestionsError struct{}
+
+func (e *multipleQuestionsError) Error() string {
+	return "dns message has more than one question"
+}
+
+func (e *multipleQuestionsError) Unwrap() error {
+	return ErrInvalidHeader
+}
+
 // ParseMessage parses dns request from payload into dst and returns the error.
 func ParseMessage(dst *Message, payload []byte, copying bool) error {
+	return parseMessage(dst, payload, copying, false, false)
+}
+
+// ParseSingleQuestion parses dns request from payload into dst as ParseMessage, but returns ErrMultipleQuestions
+// instead of the generic ErrInvalidHeader if the request has more than one question, so that a server can tell it
+// apart from other header problems.
+func ParseSingleQuestion(dst *Message, payload []byte, copying bool) error {
+	return parseMessage(dst, payload, copying, false, true)
+}
+
+// ParseResponse parses dns response from payload into dst as ParseMessage, but also accepts a response
+// with QR=1 and no question, which some responders return, leaving dst.Question and dst.Domain empty.
+// Servers shall use ParseMessage, so that such a message is not answered.
+func ParseResponse(dst *Message, payload []byte, copying bool) error {
+	return parseMessage(dst, payload, copying, true, false)
+}
+
+func parseMessage(dst *Message, payload []byte, copying, response, single bool) error {
 	if copying {
 		dst.Raw = append(dst.Raw[:0], payload...)
 		payload = dst.Raw
@@ -109,163 +151,1397 @@ func ParseMessage(dst *Message, payload []byte, copying bool) error {
 		return ErrInvalidHeader
 	}
 
-	// hint golang compiler remove ip bounds check
-	_ = payload[11]
+	// hint golang compiler remove ip bounds check
+	_ = payload[11]
+
+	// ID
+	dst.Header.ID = uint16(payload[0])<<8 | uint16(payload[1])
+
+	// RD, TC, AA, Opcode, QR, RA, Z, RCODE
+	dst.Header.Flags = Flags(payload[2])<<8 | Flags(payload[3])
+
+	// QDCOUNT, ANCOUNT, NSCOUNT, ARCOUNT
+	dst.Header.QDCount = uint16(payload[4])<<8 | uint16(payload[5])
+	dst.Header.ANCount = uint16(payload[6])<<8 | uint16(payload[7])
+	dst.Header.NSCount = uint16(payload[8])<<8 | uint16(payload[9])
+	dst.Header.ARCount = uint16(payload[10])<<8 | uint16(payload[11])
+
+	if dst.Header.QDCount > 1 && single {
+		return ErrMultipleQuestions
+	}
+	if response && dst.Header.QDCount == 0 && dst.Header.Flags.QR() == 1 {
+		// some responders omit the question in responses
+		dst.Question.Name = nil
+		dst.Question.Type = 0
+		dst.Question.Class = 0
+		dst.Domain = dst.Domain[:0]
+		return nil
+	}
+	if dst.Header.QDCount != 1 {
+		return ErrInvalidHeader
+	}
+
+	// QNAME
+	payload = payload[12:]
+	var i int
+	var b byte
+	for i, b = range payload {
+		if b == 0 {
+			break
+		}
+	}
+	if i == 0 || i+5 > len(payload) {
+		return ErrInvalidQuestion
+	}
+	dst.Question.Name = payload[:i+1]
+
+	// QTYPE, QCLASS
+	payload = payload[i:]
+	dst.Question.Class = Class(uint16(payload[4]) | uint16(payload[3])<<8)
+	dst.Question.Type = Type(uint16(payload[2]) | uint16(payload[1])<<8)
+
+	// Domain
+	dst.Domain = decodeDomainInto(dst.Domain[:0], dst.Question.Name)
+
+	return nil
+}
+
+// decodeDomainInto appends the domain of the uncompressed and non-root QNAME qname to dst,
+// e.g. "\x03www\x07example\x03org\x00" is decoded to "www.example.org".
+func decodeDomainInto(dst []byte, qname []byte) []byte {
+	pos := len(dst)
+	i := pos + int(qname[0])
+	dst = append(dst, qname[1:]...)
+	for i < len(dst) && dst[i] != 0 {
+		j := int(dst[i])
+		dst[i] = '.'
+		i += j + 1
+	}
+	return dst[:len(dst)-1]
+}
+
+// MessageQuestion is an entry of the question section parsed by ParseMessageMulti.
+type MessageQuestion struct {
+	Name  []byte
+	Type  Type
+	Class Class
+}
+
+// ParseMessageMulti parses payload into dst as ParseMessage, but accepts any QDCOUNT and appends every question
+// to questions, which is returned for reuse. dst.Question and dst.Domain refer to the first question.
+// The question names may contain compression pointers if QDCOUNT > 1.
+func ParseMessageMulti(dst *Message, questions []MessageQuestion, payload []byte, copying bool) ([]MessageQuestion, error) {
+	if copying {
+		dst.Raw = append(dst.Raw[:0], payload...)
+		payload = dst.Raw
+	}
+
+	err := ParseSingleQuestion(dst, payload, false)
+	switch {
+	case err == nil:
+		questions = append(questions, MessageQuestion{dst.Question.Name, dst.Question.Type, dst.Question.Class})
+		return questions, nil
+	case err != ErrMultipleQuestions:
+		return questions, err
+	}
+
+	off := 12
+	for i := range int(dst.Header.QDCount) {
+		end := skipName(payload, off)
+		if end < 0 || end+4 > len(payload) {
+			return questions, ErrInvalidQuestion
+		}
+		q := MessageQuestion{
+			Name:  payload[off:end],
+			Type:  Type(payload[end])<<8 | Type(payload[end+1]),
+			Class: Class(payload[end+2])<<8 | Class(payload[end+3]),
+		}
+		if i == 0 {
+			m := Message{Raw: payload}
+			if dst.Domain, err = m.decodeName(dst.Domain[:0], q.Name); err != nil {
+				return questions, ErrInvalidQuestion
+			}
+			dst.Question.Name, dst.Question.Type, dst.Question.Class = q.Name, q.Type, q.Class
+		}
+		questions = append(questions, q)
+		off = end + 4
+	}
+
+	return questions, nil
+}
+
+// AppendMessageMulti appends the wire format of msg to dst as AppendMessageNoAdditional does, but with questions as
+// the question section and QDCOUNT=len(questions). The names of questions are uncompressed or refer to msg.Raw, as
+// returned by ParseMessageMulti, and each name is compressed against the earlier questions. The records follow
+// as they are in msg.Raw, so the round trip of a message parsed by ParseMessageMulti is byte-exact.
+func (msg *Message) AppendMessageMulti(dst []byte, questions []MessageQuestion) ([]byte, error) {
+	if len(questions) > 0xffff {
+		return dst, ErrInvalidHeader
+	}
+
+	// the records follow the question section of msg.Raw
+	off := 12
+	for range msg.Header.QDCount {
+		if off = skipName(msg.Raw, off); off < 0 || off+4 > len(msg.Raw) {
+			return dst, ErrInvalidQuestion
+		}
+		off += 4
+	}
+
+	start := len(dst)
+	header := msg.Header
+	header.QDCount = uint16(len(questions))
+	dst = (&Message{Header: header}).AppendHeader(dst)
+
+	// the suffixes of the names emitted so far, with their offsets for compression pointers
+	type suffix struct {
+		off  int
+		name []byte
+	}
+	var suffixes []suffix
+	var names []byte
+	for _, q := range questions {
+		n := len(names)
+		var ok bool
+		if names, ok = msg.appendQuestionName(names, q.Name); !ok {
+			return dst[:start], ErrInvalidQuestion
+		}
+		// names only grows, so the suffixes keep referring to the decoded names
+		name := names[n:]
+
+		pos, i := len(dst)-start, 0
+		for ; name[i] != 0; i += int(name[i]) + 1 {
+			if j := slices.IndexFunc(suffixes, func(s suffix) bool { return bytes.Equal(s.name, name[i:]) }); j >= 0 {
+				dst = append(dst, name[:i]...)
+				dst = append(dst, 0b11000000|byte(suffixes[j].off>>8), byte(suffixes[j].off))
+				break
+			}
+			if pos+i < 0x4000 {
+				suffixes = append(suffixes, suffix{pos + i, name[i:]})
+			}
+		}
+		if name[i] == 0 {
+			dst = append(dst, name...)
+		}
+		dst = append(dst, byte(q.Type>>8), byte(q.Type), byte(q.Class>>8), byte(q.Class))
+	}
+
+	return append(dst, msg.Raw[off:]...), nil
+}
+
+// appendQuestionName appends the uncompressed wire form of name to dst, the compression pointers in name
+// refer to msg.Raw. It reports false if name is malformed.
+func (msg *Message) appendQuestionName(dst []byte, name []byte) ([]byte, bool) {
+	pos := len(dst)
+	for i := 0; i < len(name); i += int(name[i]) + 1 {
+		b := int(name[i])
+		switch {
+		case b == 0:
+			dst = append(dst, 0)
+			return dst, len(dst)-pos <= 255
+		case b&0b11000000 == 0b11000000:
+			if i+2 > len(name) {
+				return dst, false
+			}
+			var next int
+			dst, next = msg.appendCanonicalName(dst, (b&0b00111111)<<8|int(name[i+1]), false)
+			return dst, next >= 0 && len(dst)-pos <= 255
+		case b&0b11000000 != 0, i+b+1 > len(name):
+			return dst, false
+		}
+		dst = append(dst, name[i:i+b+1]...)
+	}
+	return dst, false
+}
+
+// ParseError is returned by ParseAndValidate, it describes the offset in the payload where parsing failed.
+type ParseError struct {
+	Offset int
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return "dns message at offset " + strconv.Itoa(e.Offset) + ": " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseAndValidate parses payload into dst as ParseMessage with dst.Raw referencing payload, then validates that the
+// record counts match the payload without trailing bytes and that the names of records are well formed, including
+// the names in CNAME, DNAME, NS, PTR and MX RDATA. The returned error is a *ParseError wrapping the ErrInvalid* error.
+func ParseAndValidate(dst *Message, payload []byte) error {
+	dst.Raw = payload
+	if err := ParseSingleQuestion(dst, payload, false); err != nil {
+		off := 0
+		switch err {
+		case ErrMultipleQuestions:
+			// QDCOUNT
+			off = 4
+		case ErrInvalidQuestion:
+			off = 12
+		case ErrInvalidHeader:
+			if len(payload) >= 12 && dst.Header.QDCount == 0 {
+				// the missing question
+				off = 12
+			}
+		}
+		return &ParseError{Offset: off, Err: err}
+	}
+
+	var buf [256]byte
+	off := 12 + len(dst.Question.Name) + 4
+	var err error
+	walkErr := dst.walk(func(i, o, rdata, end int) bool {
+		off = o
+		if _, err = dst.decodeName(buf[:0], dst.Raw[o:rdata-10]); err != nil {
+			return false
+		}
+		off = rdata
+		switch Type(dst.Raw[rdata-10])<<8 | Type(dst.Raw[rdata-9]) {
+		case TypeCNAME, TypeDNAME, TypeNS, TypePTR:
+			_, err = dst.decodeName(buf[:0], dst.Raw[rdata:end])
+		case TypeMX:
+			if off += 2; off >= end {
+				err = ErrInvalidAnswer
+				break
+			}
+			_, err = dst.decodeName(buf[:0], dst.Raw[off:end])
+		}
+		if err != nil {
+			return false
+		}
+		off = end
+		return true
+	})
+	if err == nil {
+		err = walkErr
+	}
+	if err != nil {
+		return &ParseError{Offset: off, Err: err}
+	}
+	if off != len(payload) {
+		// the record counts do not cover the trailing bytes
+		return &ParseError{Offset: off, Err: ErrInvalidHeader}
+	}
+
+	return nil
+}
+
+// LooksLikeDNS reports whether payload is plausibly a DNS message by its header only, without decoding names,
+// as a cheap pre-filter for packet captures. It checks that the header is complete, that QDCOUNT is 0 or 1,
+// that the Opcode is assigned and the reserved Z bit is clear, and that payload is long enough for the record counts.
+func LooksLikeDNS(payload []byte) bool {
+	if len(payload) < 12 {
+		return false
+	}
+
+	flags := Flags(payload[2])<<8 | Flags(payload[3])
+	switch opcode := flags.Opcode(); {
+	case opcode == 3 || opcode > 6:
+		// unassigned, 6 is DSO of RFC 8490
+		return false
+	case flags&0b0000000001000000 != 0:
+		// Z, while AD and CD are the other bits of the Z field in RFC 1035
+		return false
+	}
+
+	qdcount := int(payload[4])<<8 | int(payload[5])
+	if qdcount > 1 {
+		return false
+	}
+	rrcount := (int(payload[6])<<8 | int(payload[7])) + (int(payload[8])<<8 | int(payload[9])) + (int(payload[10])<<8 | int(payload[11]))
+
+	// a question is at least 5 bytes and a record is at least 11 bytes
+	return 12+5*qdcount+11*rrcount <= len(payload)
+}
+
+// QuestionLen returns the length of the question section starting at offset 12 of payload, that is
+// the length of QNAME plus 4 bytes of QTYPE and QCLASS. Compression pointers are rejected in QNAME.
+func QuestionLen(payload []byte) (int, error) {
+	if len(payload) < 12 {
+		return 0, ErrInvalidHeader
+	}
+
+	for i := 12; i < len(payload); {
+		b := int(payload[i])
+		switch {
+		case b == 0:
+			if i+5 > len(payload) {
+				return 0, ErrInvalidQuestion
+			}
+			return i + 5 - 12, nil
+		case b&0b11000000 != 0:
+			return 0, ErrInvalidQuestion
+		}
+		i += b + 1
+	}
+
+	return 0, ErrInvalidQuestion
+}
+
+// AppendRcodeRaw appends to dst the response of the raw query with RCODE=rcode, without parsing query into
+// a Message. The ID, Opcode, RD and the question are copied verbatim, QR=1 and the answer, authority and
+// additional sections, including any OPT record, are dropped.
+func AppendRcodeRaw(dst, query []byte, rcode Rcode) ([]byte, error) {
+	n, err := QuestionLen(query)
+	if err != nil {
+		return dst, err
+	}
+	if qdcount := uint16(query[4])<<8 | uint16(query[5]); qdcount != 1 {
+		return dst, ErrInvalidHeader
+	}
+
+	// QR = 1, RCODE = rcode, Opcode and RD are copied
+	//
+	//   0  1  2  3  4  5  6  7  8  9  A  B  C  D  E  F
+	// +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+	// |QR|   Opcode  |AA|TC|RD|RA|   Z    |   RCODE   |
+	// +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+	dst = append(dst,
+		// ID
+		query[0], query[1],
+		// Flags
+		0b10000000|query[2]&0b01111001, byte(rcode)&0b00001111,
+		// QDCOUNT, ANCOUNT, NSCOUNT, ARCOUNT
+		0, 1, 0, 0, 0, 0, 0, 0,
+	)
+
+	// QNAME, QTYPE, QCLASS
+	return append(dst, query[12:12+n]...), nil
+}
+
+// AppendDomainLower appends the question domain of msg in lower case to dst, which avoids allocating
+// a string and lowercasing it in a separate pass when filtering domains.
+func (msg *Message) AppendDomainLower(dst []byte) []byte {
+	for _, c := range msg.Domain {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// DecodeName decodes dns labels to dst. If the name is malformed, e.g. a compression pointer is out of range,
+// or the name is longer than 255 bytes, dst is returned unchanged.
+func (msg *Message) DecodeName(dst []byte, name []byte) []byte {
+	// fast path for domain pointer
+	if len(name) >= 2 && name[1] == 12 && name[0] == 0b11000000 {
+		return append(dst, msg.Domain...)
+	}
+
+	dst, _ = msg.decodeName(dst, name)
+	return dst
+}
+
+// DecodeCNAME decodes the target name in the RDATA data of a CNAME record of msg to dst, following
+// compression pointers into Raw. As DecodeName, dst is returned unchanged if the name is malformed.
+func (msg *Message) DecodeCNAME(dst []byte, data []byte) []byte {
+	return msg.DecodeName(dst, data)
+}
+
+// DecodeMX decodes the RDATA data of a MX record of msg, and appends the exchange name to dst following
+// compression pointers into Raw. As DecodeName, exchange is dst unchanged if the RDATA is malformed.
+func (msg *Message) DecodeMX(dst []byte, data []byte) (preference uint16, exchange []byte) {
+	if len(data) < 3 {
+		return 0, dst
+	}
+	return uint16(data[0])<<8 | uint16(data[1]), msg.DecodeName(dst, data[2:])
+}
+
+// DecodeSRV decodes the RDATA data of a SRV record of msg, and appends the target name to dst following
+// compression pointers into Raw. As DecodeName, target is dst unchanged if the RDATA is malformed.
+func (msg *Message) DecodeSRV(dst []byte, data []byte) (priority, weight, port uint16, target []byte) {
+	if len(data) < 7 {
+		return 0, 0, 0, dst
+	}
+	priority = uint16(data[0])<<8 | uint16(data[1])
+	weight = uint16(data[2])<<8 | uint16(data[3])
+	port = uint16(data[4])<<8 | uint16(data[5])
+	return priority, weight, port, msg.DecodeName(dst, data[6:])
+}
+
+// DecodeSOA decodes the RDATA data of a SOA record of msg, the names are decoded following compression pointers
+// into Raw. It returns ErrInvalidAnswer if data is not the two names plus 20 bytes or a name is malformed.
+func (msg *Message) DecodeSOA(data []byte) (mname, rname []byte, serial, refresh, retry, expire, minimum uint32, err error) {
+	n := skipName(data, 0)
+	if n >= 0 {
+		n = skipName(data, n)
+	}
+	if n < 0 || n+20 != len(data) {
+		err = ErrInvalidAnswer
+		return
+	}
+	if mname, err = msg.decodeName(nil, data); err != nil {
+		err = ErrInvalidAnswer
+		return
+	}
+	if rname, err = msg.decodeName(nil, data[skipName(data, 0):]); err != nil {
+		err = ErrInvalidAnswer
+		return
+	}
+
+	b := data[n:]
+	serial = uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	refresh = uint32(b[4])<<24 | uint32(b[5])<<16 | uint32(b[6])<<8 | uint32(b[7])
+	retry = uint32(b[8])<<24 | uint32(b[9])<<16 | uint32(b[10])<<8 | uint32(b[11])
+	expire = uint32(b[12])<<24 | uint32(b[13])<<16 | uint32(b[14])<<8 | uint32(b[15])
+	minimum = uint32(b[16])<<24 | uint32(b[17])<<16 | uint32(b[18])<<8 | uint32(b[19])
+	return
+}
+
+// decodeName decodes the dns labels at the beginning of name to dst, following compression pointers into msg.Raw.
+// It returns ErrInvalidName and dst unchanged if the name is truncated, has a bad label or pointer, follows too
+// many pointers, has more than 127 labels, or is longer than 255 bytes in wire format.
+func (msg *Message) decodeName(dst []byte, name []byte) ([]byte, error) {
+	pos, length, labels, hops := len(dst), 0, 0, 0
+	for len(name) != 0 {
+		b := int(name[0])
+		switch {
+		case b == 0:
+			if len(dst) > pos {
+				// trailing dot
+				dst = dst[:len(dst)-1]
+			}
+			return dst, nil
+		case b&0b11000000 == 0b11000000:
+			if len(name) < 2 {
+				return dst[:pos], ErrInvalidName
+			}
+			offset := (b&0b00111111)<<8 | int(name[1])
+			if hops++; hops > maxNamePointers || offset >= len(msg.Raw) {
+				return dst[:pos], ErrInvalidName
+			}
+			name = msg.Raw[offset:]
+			continue
+		case b&0b11000000 != 0, b+1 > len(name):
+			return dst[:pos], ErrInvalidName
+		}
+		if labels++; labels > maxNameLabels {
+			return dst[:pos], ErrInvalidName
+		}
+		// the wire length includes the terminating zero label
+		if length += b + 1; length > 254 {
+			return dst[:pos], ErrInvalidName
+		}
+		dst = append(dst, name[1:b+1]...)
+		dst = append(dst, '.')
+		name = name[b+1:]
+	}
+
+	return dst[:pos], ErrInvalidName
+}
+
+const (
+	// maxNamePointers is the maximum number of compression pointers followed in a name.
+	maxNamePointers = 127
+	// maxNameLabels is the maximum number of labels in a name, excluding the root label.
+	maxNameLabels = 127
+)
+
+// Section denotes a resource record section of the DNS message.
+type Section byte
+
+// Resource record sections.
+const (
+	SectionAnswer     Section = 1
+	SectionAuthority  Section = 2
+	SectionAdditional Section = 3
+)
+
+func (s Section) String() string {
+	switch s {
+	case SectionAnswer:
+		return "answer"
+	case SectionAuthority:
+		return "authority"
+	case SectionAdditional:
+		return "additional"
+	}
+	return ""
+}
+
+type MessageRecord struct {
+	Name  []byte
+	Type  Type
+	Class Class
+	TTL   uint32
+	Data  []byte
+}
+
+// Records calls f for each resource record in the answer and authority sections of msg in the original order,
+// until f returns false. It stops at the first malformed record.
+func (msg *Message) Records(f func(MessageRecord) bool) {
+	n := int(msg.Header.ANCount) + int(msg.Header.NSCount)
+	if n == 0 {
+		return
+	}
+
+	_ = msg.walk(func(i, off, rdata, end int) bool {
+		return i < n && f(msg.record(off, rdata, end))
+	})
+}
+
+// KnownAnswers calls f for each record in the answer section of the multicast DNS query msg, which are the answers
+// known by the querier, see RFC 6762 section 7.1, until f returns false. The name refers to Raw and may be compressed,
+// and the top bit of the class, the cache-flush bit, is cleared. It returns the error of the first malformed record.
+func (msg *Message) KnownAnswers(f func(name []byte, typ Type, class Class, ttl uint32, data []byte) bool) error {
+	n := int(msg.Header.ANCount)
+	if n == 0 {
+		return nil
+	}
+
+	return msg.walk(func(i, off, rdata, end int) bool {
+		if i >= n {
+			return false
+		}
+		r := msg.record(off, rdata, end)
+		return f(r.Name, r.Type, r.Class&0x7fff, r.TTL, r.Data)
+	})
+}
+
+// AppendIPs appends the addresses of A and AAAA records in the answer section to dst.
+// If unmap is true, IPv4-mapped IPv6 addresses in AAAA records are converted to IPv4 addresses.
+func (msg *Message) AppendIPs(dst []netip.Addr, unmap bool) []netip.Addr {
+	var i uint16
+	for r := range msg.Records {
+		if i++; i > msg.Header.ANCount {
+			break
+		}
+		dst = appendIP(dst, r, unmap)
+	}
+	return dst
+}
+
+// FirstIP returns the address of the first A or AAAA record in the answer section.
+// If unmap is true, an IPv4-mapped IPv6 address is converted to an IPv4 address.
+func (msg *Message) FirstIP(unmap bool) (ip netip.Addr, ok bool) {
+	var ips [1]netip.Addr
+	var i uint16
+	for r := range msg.Records {
+		if i++; i > msg.Header.ANCount {
+			break
+		}
+		if s := appendIP(ips[:0], r, unmap); len(s) != 0 {
+			return s[0], true
+		}
+	}
+	return
+}
+
+// FinalAnswers follows the CNAME chain in the answer section of msg starting from the question name, and
+// appends to dst the addresses of the A and AAAA records owned by the end of the chain. A chain ending in
+// a CNAME target without records appends nothing and is not an error.
+func (msg *Message) FinalAnswers(dst []netip.Addr) ([]netip.Addr, error) {
+	var name, target [256]byte
+	owner := append(name[:0], msg.Domain...)
+
+	// a CNAME loop is not detected, but the chain is followed at most ANCount hops of ANCount records each
+	for range msg.Header.ANCount {
+		found := false
+		err := msg.walk(func(i, off, rdata, end int) bool {
+			if msg.section(i) != SectionAnswer {
+				return false
+			}
+			r := msg.record(off, rdata, end)
+			if r.Type == TypeCNAME && equalFold(msg.DecodeName(target[:0], r.Name), owner) {
+				owner = append(name[:0], msg.DecodeName(target[:0], r.Data)...)
+				found = true
+			}
+			return !found
+		})
+		if err != nil {
+			return dst, err
+		}
+		if !found {
+			break
+		}
+	}
+
+	err := msg.walk(func(i, off, rdata, end int) bool {
+		if msg.section(i) != SectionAnswer {
+			return false
+		}
+		r := msg.record(off, rdata, end)
+		if (r.Type == TypeA || r.Type == TypeAAAA) && equalFold(msg.DecodeName(target[:0], r.Name), owner) {
+			dst = appendIP(dst, r, false)
+		}
+		return true
+	})
+
+	return dst, err
+}
+
+// RRset appends to dst the RDATA of the answer records of typ owned by name, the RRset of RFC 2181, or of all
+// the answer records owned by name for TypeANY. name is in the dotted form of Domain and compared
+// case-insensitively. The RDATA refer to Raw.
+func (msg *Message) RRset(name []byte, typ Type, dst [][]byte) ([][]byte, error) {
+	var owner [256]byte
+	var nerr error
+	err := msg.walk(func(i, off, rdata, end int) bool {
+		if msg.section(i) != SectionAnswer {
+			return false
+		}
+		r := msg.record(off, rdata, end)
+		if r.Type != typ && typ != TypeANY {
+			return true
+		}
+		var b []byte
+		if b, nerr = msg.decodeName(owner[:0], r.Name); nerr != nil {
+			return false
+		}
+		if equalFold(b, name) {
+			dst = append(dst, r.Data)
+		}
+		return true
+	})
+	if err == nil {
+		err = nerr
+	}
+	return dst, err
+}
+
+// ParseAddr returns the address of the RDATA data of an A or AAAA record of typ, ok is false if typ is
+// neither A nor AAAA or data is not 4 or 16 bytes respectively.
+func ParseAddr(typ Type, data []byte) (ip netip.Addr, ok bool) {
+	switch {
+	case typ == TypeA && len(data) == 4:
+		return netip.AddrFrom4(*(*[4]byte)(data)), true
+	case typ == TypeAAAA && len(data) == 16:
+		return netip.AddrFrom16(*(*[16]byte)(data)), true
+	}
+	return
+}
+
+func appendIP(dst []netip.Addr, r MessageRecord, unmap bool) []netip.Addr {
+	if ip, ok := ParseAddr(r.Type, r.Data); ok {
+		if unmap {
+			ip = ip.Unmap()
+		}
+		dst = append(dst, ip)
+	}
+	return dst
+}
+
+// SinkholeA rewrites in place the RDATA of answer records to ip, leaving names and TTLs intact.
+// A records are rewritten if ip is an IPv4 address, AAAA records if ip is an IPv6 address.
+func (msg *Message) SinkholeA(ip netip.Addr) {
+	typ := TypeA
+	if !ip.Is4() {
+		typ = TypeAAAA
+	}
+	var i uint16
+	for r := range msg.Records {
+		if i++; i > msg.Header.ANCount {
+			break
+		}
+		switch {
+		case r.Type != typ:
+			continue
+		case typ == TypeA && len(r.Data) == 4:
+			b := ip.As4()
+			copy(r.Data, b[:])
+		case typ == TypeAAAA && len(r.Data) == 16:
+			b := ip.As16()
+			copy(r.Data, b[:])
+		}
+	}
+}
+
+// Synthesize64 builds into dst an AAAA response from the A response msg, embedding each IPv4 address
+// of the answer section into the NAT64 prefix as described in RFC 6052. CNAME records are kept as is.
+func (msg *Message) Synthesize64(prefix netip.Prefix, dst *Message) error {
+	switch prefix.Bits() {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return ErrInvalidPrefix
+	}
+	if !prefix.Addr().Is6() {
+		return ErrInvalidPrefix
+	}
+
+	class := msg.Question.Class
+
+	// Header, NSCOUNT and ARCOUNT are dropped
+	dst.Raw = append(dst.Raw[:0], msg.Raw[:8]...)
+	dst.Raw = append(dst.Raw, 0, 0, 0, 0)
+
+	// QNAME, QTYPE, QCLASS
+	dst.Raw = append(dst.Raw, msg.Question.Name...)
+	dst.Raw = append(dst.Raw, byte(TypeAAAA>>8), byte(TypeAAAA), byte(class>>8), byte(class))
+
+	var buf [256]byte
+	var ancount, i uint16
+	for r := range msg.Records {
+		if i++; i > msg.Header.ANCount {
+			break
+		}
+		if r.Type != TypeA && r.Type != TypeCNAME {
+			continue
+		}
+		if r.Type == TypeA && len(r.Data) != 4 {
+			return ErrInvalidAnswer
+		}
+
+		// NAME, names are decompressed because record offsets change
+		dst.Raw = EncodeDomain(dst.Raw, b2s(msg.DecodeName(buf[:0], r.Name)))
+
+		if r.Type == TypeCNAME {
+			cname := msg.DecodeName(buf[:0], r.Data)
+			dst.Raw = append(dst.Raw,
+				// TYPE
+				0x00, byte(TypeCNAME),
+				// CLASS
+				byte(r.Class>>8), byte(r.Class),
+				// TTL
+				byte(r.TTL>>24), byte(r.TTL>>16), byte(r.TTL>>8), byte(r.TTL),
+				// RDLENGTH
+				0x00, byte(len(cname)+2),
+			)
+			dst.Raw = EncodeDomain(dst.Raw, b2s(cname))
+		} else {
+			ip := prefix.Masked().Addr().As16()
+			// bits 64 to 71 of the address are reserved
+			j := prefix.Bits() / 8
+			for _, b := range r.Data {
+				if j == 8 {
+					j++
+				}
+				ip[j] = b
+				j++
+			}
+			dst.Raw = append(dst.Raw,
+				// TYPE
+				0x00, byte(TypeAAAA),
+				// CLASS
+				byte(r.Class>>8), byte(r.Class),
+				// TTL
+				byte(r.TTL>>24), byte(r.TTL>>16), byte(r.TTL>>8), byte(r.TTL),
+				// RDLENGTH
+				0x00, 0x10,
+			)
+			dst.Raw = append(dst.Raw, ip[:]...)
+		}
+		ancount++
+	}
+
+	// ANCOUNT
+	dst.Raw[6] = byte(ancount >> 8)
+	dst.Raw[7] = byte(ancount)
+
+	return ParseMessage(dst, dst.Raw, false)
+}
+
+// HasType reports whether the answer section of msg contains a record of typ, or any record for TypeANY.
+func (msg *Message) HasType(typ Type) (ok bool) {
+	n := int(msg.Header.ANCount)
+	_ = msg.walk(func(i, off, rdata, end int) bool {
+		if i >= n {
+			return false
+		}
+		ok = typ == TypeANY || Type(msg.Raw[rdata-10])<<8|Type(msg.Raw[rdata-9]) == typ
+		return !ok
+	})
+	return
+}
+
+// AnswerTTL returns the TTL of the i-th answer record of msg, ok is false if i is out of range.
+func (msg *Message) AnswerTTL(i int) (ttl uint32, ok bool) {
+	off := msg.answerTTLOffset(i)
+	if off < 0 {
+		return 0, false
+	}
+	return uint32(msg.Raw[off])<<24 | uint32(msg.Raw[off+1])<<16 | uint32(msg.Raw[off+2])<<8 | uint32(msg.Raw[off+3]), true
+}
+
+// SetAnswerTTL sets the TTL of the i-th answer record of msg in Raw, it returns false if i is out of range.
+func (msg *Message) SetAnswerTTL(i int, ttl uint32) bool {
+	off := msg.answerTTLOffset(i)
+	if off < 0 {
+		return false
+	}
+	msg.Raw[off] = byte(ttl >> 24)
+	msg.Raw[off+1] = byte(ttl >> 16)
+	msg.Raw[off+2] = byte(ttl >> 8)
+	msg.Raw[off+3] = byte(ttl)
+	return true
+}
+
+// AnswerRDATA returns the type and the RDATA of the i-th answer record of msg, ok is false if i is out of range
+// or the record is malformed. data refers to Raw.
+func (msg *Message) AnswerRDATA(i int) (typ Type, data []byte, ok bool) {
+	if i < 0 || i >= int(msg.Header.ANCount) {
+		return
+	}
+	_ = msg.walk(func(j, _, rdata, end int) bool {
+		if j == i {
+			typ, data, ok = Type(msg.Raw[rdata-10])<<8|Type(msg.Raw[rdata-9]), msg.Raw[rdata:end], true
+		}
+		return j < i
+	})
+	return
+}
+
+// answerTTLOffset returns the offset of the TTL of the i-th answer record in msg.Raw, or -1 if it is absent.
+func (msg *Message) answerTTLOffset(i int) (off int) {
+	off = -1
+	if i < 0 || i >= int(msg.Header.ANCount) {
+		return
+	}
+	_ = msg.walk(func(j, _, rdata, _ int) bool {
+		if j == i {
+			off = rdata - 6
+		}
+		return j < i
+	})
+	return
+}
+
+// ReferralHasGlue reports whether at least one NS target in the authority section of msg has an A or AAAA
+// record in the additional section. Names are compared case-insensitively.
+func (msg *Message) ReferralHasGlue() (ok bool) {
+	var targets [][]byte
+	_ = msg.walk(func(i, off, rdata, end int) bool {
+		r := msg.record(off, rdata, end)
+		switch msg.section(i) {
+		case SectionAuthority:
+			if r.Type == TypeNS {
+				targets = append(targets, msg.DecodeName(nil, r.Data))
+			}
+		case SectionAdditional:
+			if r.Type != TypeA && r.Type != TypeAAAA {
+				break
+			}
+			var buf [256]byte
+			owner := msg.DecodeName(buf[:0], r.Name)
+			for _, target := range targets {
+				if ok = equalFold(owner, target); ok {
+					return false
+				}
+			}
+		}
+		return true
+	})
+	return
+}
+
+// SOASerial returns the SERIAL of the first SOA record in the answer or authority section of msg,
+// ok is false if there is none or it is malformed.
+func (msg *Message) SOASerial() (serial uint32, ok bool) {
+	_ = msg.walk(func(i, off, rdata, end int) bool {
+		if msg.section(i) == SectionAdditional {
+			return false
+		}
+		r := msg.record(off, rdata, end)
+		if r.Type != TypeSOA {
+			return true
+		}
+		// MNAME and RNAME
+		n := skipName(r.Data, 0)
+		if n >= 0 {
+			n = skipName(r.Data, n)
+		}
+		if n >= 0 && n+4 <= len(r.Data) {
+			serial, ok = uint32(r.Data[n])<<24|uint32(r.Data[n+1])<<16|uint32(r.Data[n+2])<<8|uint32(r.Data[n+3]), true
+		}
+		return false
+	})
+	return
+}
+
+// ClosestEnclosingZone returns the longest owner name of the NS or SOA records in the authority sections of
+// referrals which is qname or an ancestor of qname, i.e. the deepest zone with a delegation for qname.
+// Names are in the dotted form of Domain and compared case-insensitively. It returns nil if there is none.
+func ClosestEnclosingZone(qname []byte, referrals []*Message) (zone []byte) {
+	var buf [256]byte
+	for _, msg := range referrals {
+		_ = msg.walk(func(i, off, rdata, end int) bool {
+			if msg.section(i) != SectionAuthority {
+				return msg.section(i) < SectionAuthority
+			}
+			r := msg.record(off, rdata, end)
+			if r.Type != TypeNS && r.Type != TypeSOA {
+				return true
+			}
+			owner := msg.DecodeName(buf[:0], r.Name)
+			if (zone == nil || len(owner) > len(zone)) && inZone(qname, owner) {
+				zone = append(zone[:0], owner...)
+				if zone == nil {
+					// the root zone
+					zone = []byte{}
+				}
+			}
+			return true
+		})
+	}
+	return
+}
+
+// inZone reports whether the dotted name is zone or a subdomain of zone, case-insensitively.
+// The empty zone is the root zone.
+func inZone(name, zone []byte) bool {
+	switch {
+	case len(zone) == 0:
+		return true
+	case len(name) == len(zone):
+		return equalFold(name, zone)
+	case len(name) > len(zone):
+		return name[len(name)-len(zone)-1] == '.' && equalFold(name[len(name)-len(zone):], zone)
+	}
+	return false
+}
+
+// IsInternetClass reports whether the question class of msg is IN, so that servers serving only
+// the IN class can refuse CHAOS, HESIOD and other classes early.
+func (msg *Message) IsInternetClass() bool {
+	return msg.Question.Class == ClassINET
+}
+
+// IsUnicastResponse reports whether the top bit of the question class is set, which is a QU question asking for
+// a unicast response in multicast DNS, see RFC 6762 section 5.4. See MDNSClass for the class without this bit.
+func (msg *Message) IsUnicastResponse() bool {
+	return msg.Header.QDCount != 0 && msg.Question.Class&0x8000 != 0
+}
+
+// MDNSClass returns the question class of the multicast DNS query msg without the unicast-response bit,
+// e.g. IN for a QU question of the IN class. Question.Class keeps the raw QCLASS.
+func (msg *Message) MDNSClass() Class {
+	return msg.Question.Class & 0x7fff
+}
+
+// MinTTL returns the minimum TTL of the records in the answer, authority and additional sections of msg,
+// excluding the OPT pseudo record, or 0 if msg has no records.
+func (msg *Message) MinTTL() (ttl uint32) {
+	first := true
+	_ = msg.walk(func(i, off, rdata, end int) bool {
+		if r := msg.record(off, rdata, end); r.Type != TypeOPT && (first || r.TTL < ttl) {
+			ttl, first = r.TTL, false
+		}
+		return true
+	})
+	return
+}
+
+// ExpiresAt returns the time when msg stored at storedAt expires, that is storedAt plus MinTTL seconds.
+func (msg *Message) ExpiresAt(storedAt time.Time) time.Time {
+	return storedAt.Add(time.Duration(msg.MinTTL()) * time.Second)
+}
+
+// ShouldPrefetch reports whether msg stored at storedAt is through the threshold fraction of its MinTTL at now,
+// e.g. a threshold of 0.9 triggers the prefetch in the last 10% of the TTL.
+func (msg *Message) ShouldPrefetch(storedAt time.Time, now time.Time, threshold float64) bool {
+	ttl := time.Duration(msg.MinTTL()) * time.Second
+	return now.Sub(storedAt) >= time.Duration(float64(ttl)*threshold)
+}
+
+// RecursionRefused reports whether msg is a response with RA=0 to req with RD=1, i.e. the server does not
+// offer recursion and the client should pick another resolver.
+func (msg *Message) RecursionRefused(req *Message) bool {
+	return req.Header.Flags.RD() == 1 && msg.Header.Flags.QR() == 1 && msg.Header.Flags.RA() == 0
+}
 
-	// ID
-	dst.Header.ID = uint16(payload[0])<<8 | uint16(payload[1])
+// SameQuestion reports whether a and b have the same question, the names are compared case-insensitively
+// and the IDs are ignored.
+func SameQuestion(a, b *Message) bool {
+	return a.Question.Type == b.Question.Type &&
+		a.Question.Class == b.Question.Class &&
+		equalFold(a.Domain, b.Domain)
+}
 
-	// RD, TC, AA, Opcode, QR, RA, Z, RCODE
-	dst.Header.Flags = Flags(payload[2])<<8 | Flags(payload[3])
+// OpcodeMatches reports whether msg is a response carrying the same opcode as req, a response
+// with another opcode is either spoofed or broken and should be rejected.
+func (msg *Message) OpcodeMatches(req *Message) bool {
+	return msg.Header.Flags.QR() == 1 && msg.Header.Flags.Opcode() == req.Header.Flags.Opcode()
+}
 
-	// QDCOUNT, ANCOUNT, NSCOUNT, ARCOUNT
-	dst.Header.QDCount = uint16(payload[4])<<8 | uint16(payload[5])
-	dst.Header.ANCount = uint16(payload[6])<<8 | uint16(payload[7])
-	dst.Header.NSCount = uint16(payload[8])<<8 | uint16(payload[9])
-	dst.Header.ARCount = uint16(payload[10])<<8 | uint16(payload[11])
+// TTLs appends the TTL of every record in the answer, authority and additional sections of msg to dst,
+// the OPT pseudo record is skipped since its TTL field carries EDNS flags.
+func (msg *Message) TTLs(dst []uint32) ([]uint32, error) {
+	err := msg.walk(func(i, off, rdata, end int) bool {
+		if r := msg.record(off, rdata, end); r.Type != TypeOPT {
+			dst = append(dst, r.TTL)
+		}
+		return true
+	})
+	return dst, err
+}
 
-	if dst.Header.QDCount != 1 {
-		return ErrInvalidHeader
-	}
+// CapTTLs lowers the TTL of every record in the answer, authority and additional sections of msg to at most ttl
+// in Raw, the OPT pseudo record is skipped since its TTL field carries EDNS flags.
+func (msg *Message) CapTTLs(ttl uint32) error {
+	return msg.walk(func(i, off, rdata, end int) bool {
+		if r := msg.record(off, rdata, end); r.Type != TypeOPT && r.TTL > ttl {
+			msg.Raw[rdata-6] = byte(ttl >> 24)
+			msg.Raw[rdata-5] = byte(ttl >> 16)
+			msg.Raw[rdata-4] = byte(ttl >> 8)
+			msg.Raw[rdata-3] = byte(ttl)
+		}
+		return true
+	})
+}
 
-	// QNAME
-	payload = payload[12:]
-	var i int
-	var b byte
-	for i, b = range payload {
-		if b == 0 {
+// ContentHash returns a hash of the answer records in msg, covering names, types, classes and rdata.
+// TTLs, the message ID and the order of records are ignored, so semantically identical responses hash equally.
+func (msg *Message) ContentHash() (sum uint64) {
+	var buf [512]byte
+	var i uint16
+	for r := range msg.Records {
+		if i++; i > msg.Header.ANCount {
 			break
 		}
+		// FNV-1a of the canonical record key, summed to be order insensitive.
+		h := uint64(14695981039346656037)
+		for _, b := range msg.appendRecordKey(buf[:0], r) {
+			h ^= uint64(b)
+			h *= 1099511628211
+		}
+		sum += h
 	}
-	if i == 0 || i+5 > len(payload) {
-		return ErrInvalidQuestion
-	}
-	dst.Question.Name = payload[:i+1]
-
-	// QTYPE, QCLASS
-	payload = payload[i:]
-	dst.Question.Class = Class(uint16(payload[4]) | uint16(payload[3])<<8)
-	dst.Question.Type = Type(uint16(payload[2]) | uint16(payload[1])<<8)
+	return
+}
 
-	// Domain
-	i = int(dst.Question.Name[0])
-	payload = append(dst.Domain[:0], dst.Question.Name[1:]...)
-	for i < len(payload) && payload[i] != 0 {
-		j := int(payload[i])
-		payload[i] = '.'
-		i += j + 1
+// appendRecordKey appends a case-insensitive and compression-free form of r to dst,
+// two records share the same key if they are semantically identical regardless of TTL.
+func (msg *Message) appendRecordKey(dst []byte, r MessageRecord) []byte {
+	dst = msg.appendLowerName(dst, r.Name)
+	dst = append(dst, 0, byte(r.Type>>8), byte(r.Type), byte(r.Class>>8), byte(r.Class))
+	switch r.Type {
+	case TypeCNAME, TypeNS, TypePTR, TypeDNAME:
+		dst = msg.appendLowerName(dst, r.Data)
+	case TypeMX:
+		if len(r.Data) > 2 {
+			dst = msg.appendLowerName(append(dst, r.Data[:2]...), r.Data[2:])
+		}
+	default:
+		dst = append(dst, r.Data...)
 	}
-	dst.Domain = payload[:len(payload)-1]
+	return dst
+}
 
-	return nil
+// appendLowerName decodes name to dst in lower case.
+func (msg *Message) appendLowerName(dst []byte, name []byte) []byte {
+	pos := len(dst)
+	dst = msg.DecodeName(dst, name)
+	lower(dst[pos:])
+	return dst
 }
 
-// DecodeName decodes dns labels to dst.
-func (msg *Message) DecodeName(dst []byte, name []byte) []byte {
-	if len(name) < 2 {
-		return dst
+// Canonicalize rewrites msg.Raw into a deterministic form suitable as a cache entry: the ID is zeroed,
+// names are decompressed, owner names and the names in CNAME, DNAME, NS, PTR and MX RDATA are lowercased,
+// and the records of each section are sorted so that RRsets are contiguous. TTLs are kept intact.
+// A malformed msg is left unchanged.
+func (msg *Message) Canonicalize() {
+	if len(msg.Raw) < 12 {
+		return
 	}
 
-	// fast path for domain pointer
-	if name[1] == 12 && name[0] == 0b11000000 {
-		return append(dst, msg.Domain...)
-	}
+	// ID
+	raw := append(make([]byte, 0, 2*len(msg.Raw)), 0, 0)
+	raw = append(raw, msg.Raw[2:12]...)
 
-	pos := len(dst)
-	var offset int
-	if name[len(name)-1] == 0 {
-		dst = append(dst, name...)
-	} else {
-		dst = append(dst, name[:len(name)-2]...)
-		offset = int(name[len(name)-2]&0b00111111)<<8 + int(name[len(name)-1])
+	off := 12
+	for range msg.Header.QDCount {
+		if raw, off = msg.appendCanonicalName(raw, off, true); off < 0 || off+4 > len(msg.Raw) {
+			return
+		}
+		raw = append(raw, msg.Raw[off:off+4]...)
+		off += 4
 	}
+	qend := len(raw)
 
-	for offset != 0 {
-		for i := offset; i < len(msg.Raw); {
-			b := int(msg.Raw[i])
-			if b == 0 {
-				offset = 0
-				dst = append(dst, 0)
-				break
-			} else if b&0b11000000 == 0b11000000 {
-				offset = int(b&0b00111111)<<8 + int(msg.Raw[i+1])
-				break
+	var spans [3][][2]int
+	ok := true
+	err := msg.walk(func(i, off, rdata, end int) bool {
+		start := len(raw)
+		// NAME
+		if raw, off = msg.appendCanonicalName(raw, off, true); off < 0 {
+			ok = false
+			return false
+		}
+		// TYPE, CLASS, TTL
+		raw = append(raw, msg.Raw[rdata-10:rdata-2]...)
+		// RDLENGTH
+		n := len(raw)
+		raw = append(raw, 0, 0)
+		// RDATA
+		switch Type(msg.Raw[rdata-10])<<8 | Type(msg.Raw[rdata-9]) {
+		case TypeCNAME, TypeDNAME, TypeNS, TypePTR:
+			raw, off = msg.appendCanonicalName(raw, rdata, true)
+		case TypeMX:
+			if rdata+2 < end {
+				raw = append(raw, msg.Raw[rdata:rdata+2]...)
+				raw, off = msg.appendCanonicalName(raw, rdata+2, true)
+			} else {
+				off = -1
+			}
+		case TypeSOA:
+			// MNAME, RNAME, then SERIAL, REFRESH, RETRY, EXPIRE and MINIMUM
+			if raw, off = msg.appendCanonicalName(raw, rdata, false); off > 0 {
+				raw, off = msg.appendCanonicalName(raw, off, false)
+			}
+			if off > 0 && off+20 == end {
+				raw = append(raw, msg.Raw[off:end]...)
+				off = end
 			} else {
-				dst = append(dst, msg.Raw[i:i+b+1]...)
-				i += b + 1
+				off = -1
 			}
+		default:
+			raw = append(raw, msg.Raw[rdata:end]...)
+			off = end
+		}
+		if off != end {
+			ok = false
+			return false
+		}
+		length := len(raw) - n - 2
+		raw[n], raw[n+1] = byte(length>>8), byte(length)
+		spans[msg.section(i)-SectionAnswer] = append(spans[msg.section(i)-SectionAnswer], [2]int{start, len(raw)})
+		return true
+	})
+	if err != nil || !ok {
+		return
+	}
+
+	msg.Raw = append(msg.Raw[:0], raw[:qend]...)
+	for _, s := range spans {
+		slices.SortFunc(s, func(a, b [2]int) int {
+			return bytes.Compare(raw[a[0]:a[1]], raw[b[0]:b[1]])
+		})
+		for _, span := range s {
+			msg.Raw = append(msg.Raw, raw[span[0]:span[1]]...)
 		}
 	}
 
-	n := pos
-	for dst[pos] != 0 {
-		i := int(dst[pos])
-		dst[pos] = '.'
-		pos += i + 1
+	msg.Header.ID = 0
+	if msg.Header.QDCount != 0 {
+		msg.Question.Name = msg.Raw[12 : 12+len(msg.Question.Name)]
 	}
+	lower(msg.Domain)
+}
 
-	if n == 0 {
-		dst = dst[1 : len(dst)-1]
-	} else {
-		dst = append(dst[:n], dst[n+1:len(dst)-1]...)
+// MatchAnswerCaseTo rewrites the case of the answer owner names of msg that are equal to the QNAME of the raw
+// query, so that a response rewritten upstream matches the 0x20 randomized case of the client query. Owner names
+// compressed to the question name also change the case of the question name and Domain.
+func (msg *Message) MatchAnswerCaseTo(query []byte) {
+	n, err := QuestionLen(query)
+	if err != nil {
+		return
 	}
+	qname := query[12 : 12+n-4]
 
-	return dst
+	var buf [256]byte
+	_ = msg.walk(func(i, off, rdata, end int) bool {
+		if msg.section(i) != SectionAnswer {
+			return false
+		}
+		if name, next := msg.appendCanonicalName(buf[:0], off, false); next > 0 && equalFold(name, qname) {
+			msg.copyNameCase(off, qname)
+		}
+		return true
+	})
+
+	if msg.Header.QDCount == 1 && len(msg.Question.Name) != 0 {
+		msg.Domain = decodeDomainInto(msg.Domain[:0], msg.Question.Name)
+	}
 }
 
-type MessageRecord struct {
-	Name  []byte
-	Type  Type
-	Class Class
-	TTL   uint32
-	Data  []byte
+// copyNameCase copies the labels of the wire name to the valid name at off in msg.Raw, following compression
+// pointers. The names must be equal case-insensitively.
+func (msg *Message) copyNameCase(off int, name []byte) {
+	for len(name) != 0 && name[0] != 0 {
+		b := int(msg.Raw[off])
+		if b&0b11000000 == 0b11000000 {
+			off = (b&0b00111111)<<8 | int(msg.Raw[off+1])
+			continue
+		}
+		copy(msg.Raw[off+1:off+1+b], name[1:1+b])
+		off += b + 1
+		name = name[b+1:]
+	}
 }
 
-// Walk calls f for each item in the msg in the original order of the parsed RR.
-func (msg *Message) Records(f func(MessageRecord) bool) {
-	n := msg.Header.ANCount + msg.Header.NSCount
-	if n == 0 {
-		return
+// appendCanonicalName appends the uncompressed wire form of the name at off in msg.Raw to dst,
+// lowercased if fold is true. It returns the offset following the name at off, or -1 if the name
+// is malformed. Compression pointers must point backwards so that decompression terminates.
+func (msg *Message) appendCanonicalName(dst []byte, off int, fold bool) ([]byte, int) {
+	next, n := -1, 0
+	for off < len(msg.Raw) {
+		b := int(msg.Raw[off])
+		switch {
+		case b == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return append(dst, 0), next
+		case b&0b11000000 == 0b11000000:
+			if off+2 > len(msg.Raw) {
+				return dst, -1
+			}
+			ptr := (b&0b00111111)<<8 | int(msg.Raw[off+1])
+			if ptr >= off {
+				return dst, -1
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = ptr
+			continue
+		case b&0b11000000 != 0, off+b+1 > len(msg.Raw):
+			return dst, -1
+		}
+		if n += b + 1; n > 254 {
+			return dst, -1
+		}
+		i := len(dst)
+		dst = append(dst, msg.Raw[off:off+b+1]...)
+		if fold {
+			lower(dst[i+1:])
+		}
+		off += b + 1
+	}
+	return dst, -1
+}
+
+// AdditionalRecords calls f for each item in the msg in the original order of the parsed AR.
+func (msg *Message) AdditionalRecords(f func(MessageRecord) bool) {
+	n := int(msg.Header.ANCount) + int(msg.Header.NSCount)
+	_ = msg.walk(func(i, off, rdata, end int) bool {
+		if i < n {
+			return true
+		}
+		return f(msg.record(off, rdata, end))
+	})
+}
+
+// RawRecords calls f with the raw bytes of each resource record in section, from the owner name to the end of RDATA.
+// The names are not decoded, so raw refers to msg.Raw and may contain compression pointers.
+func (msg *Message) RawRecords(section Section, f func(raw []byte) bool) error {
+	return msg.walk(func(i, off, rdata, end int) bool {
+		switch s := msg.section(i); {
+		case s < section:
+			return true
+		case s > section:
+			return false
+		}
+		return f(msg.Raw[off:end])
+	})
+}
+
+// RawRDATA returns the type and the opaque RDATA of the raw resource record yielded by RawRecords.
+// No type specific decoding is applied, so records of unknown or experimental types pass through intact.
+func RawRDATA(raw []byte) (Type, []byte, error) {
+	rdata := skipName(raw, 0)
+	if rdata < 0 || rdata+10 > len(raw) {
+		return 0, nil, ErrInvalidAnswer
 	}
+	rdata += 10
+	if rdata+(int(raw[rdata-2])<<8|int(raw[rdata-1])) != len(raw) {
+		return 0, nil, ErrInvalidAnswer
+	}
+	return Type(raw[rdata-10])<<8 | Type(raw[rdata-9]), raw[rdata:], nil
+}
 
-	payload := msg.Raw[16+len(msg.Question.Name):]
+// section returns the section of the i-th resource record located by walk.
+func (msg *Message) section(i int) Section {
+	switch {
+	case i < int(msg.Header.ANCount):
+		return SectionAnswer
+	case i < int(msg.Header.ANCount)+int(msg.Header.NSCount):
+		return SectionAuthority
+	}
+	return SectionAdditional
+}
 
-	for i := uint16(0); i < n; i++ {
-		var name []byte
-		for j, b := range payload {
-			if b&0b11000000 == 0b11000000 {
-				name = payload[:j+2]
-				payload = payload[j+2:]
-				break
-			} else if b == 0 {
-				name = payload[:j+1]
-				payload = payload[j+1:]
-				break
-			}
+// walk calls f with the index, the offset, the RDATA offset and the end offset of each resource record
+// in msg.Raw following the question section, until f returns false. It checks all bounds so that
+// malformed messages are reported as errors.
+func (msg *Message) walk(f func(i, off, rdata, end int) bool) error {
+	payload := msg.Raw
+	if len(payload) < 12 {
+		return ErrInvalidHeader
+	}
+
+	off := 12
+	for range msg.Header.QDCount {
+		off = skipName(payload, off)
+		if off < 0 || off+4 > len(payload) {
+			return ErrInvalidQuestion
 		}
-		if name == nil {
-			return
+		off += 4
+	}
+
+	n := int(msg.Header.ANCount) + int(msg.Header.NSCount) + int(msg.Header.ARCount)
+	for i := 0; i < n; i++ {
+		rdata := skipName(payload, off)
+		if rdata < 0 || rdata+10 > len(payload) {
+			return ErrInvalidAnswer
 		}
-		_ = payload[9] // hint compiler to remove bounds check
-		typ := Type(payload[0])<<8 | Type(payload[1])
-		class := Class(payload[2])<<8 | Class(payload[3])
-		ttl := uint32(payload[4])<<24 | uint32(payload[5])<<16 | uint32(payload[6])<<8 | uint32(payload[7])
-		length := uint16(payload[8])<<8 | uint16(payload[9])
-		data := payload[10 : 10+length]
-		payload = payload[10+length:]
-		ok := f(MessageRecord{Name: name, Type: typ, Class: class, TTL: ttl, Data: data})
-		if !ok {
+		rdata += 10
+		end := rdata + (int(payload[rdata-2])<<8 | int(payload[rdata-1]))
+		if end > len(payload) {
+			return ErrInvalidAnswer
+		}
+		if !f(i, off, rdata, end) {
 			break
 		}
+		off = end
+	}
+
+	return nil
+}
+
+// record returns the resource record located by walk.
+func (msg *Message) record(off, rdata, end int) MessageRecord {
+	payload := msg.Raw[off:end]
+	rdata -= off
+	_ = payload[rdata-1] // hint compiler to remove bounds check
+	return MessageRecord{
+		Name:  payload[:rdata-10],
+		Type:  Type(payload[rdata-10])<<8 | Type(payload[rdata-9]),
+		Class: Class(payload[rdata-8])<<8 | Class(payload[rdata-7]),
+		TTL:   uint32(payload[rdata-6])<<24 | uint32(payload[rdata-5])<<16 | uint32(payload[rdata-4])<<8 | uint32(payload[rdata-3]),
+		Data:  payload[rdata:],
 	}
 }
 
-// WalkAdditionalRecords calls f for each item in the msg in the original order of the parsed AR.
-func (msg *Message) AdditionalRecords(f func(MessageRecord) bool) {
-	panic("not implemented")
+// skipName returns the offset following the name at off in payload, or -1 if the name is malformed.
+func skipName(payload []byte, off int) int {
+	for off < len(payload) {
+		b := payload[off]
+		switch {
+		case b == 0:
+			return off + 1
+		case b&0b11000000 == 0b11000000:
+			if off+2 > len(payload) {
+				return -1
+			}
+			return off + 2
+		case b&0b11000000 != 0:
+			return -1
+		}
+		off += int(b) + 1
+	}
+	return -1
 }
 
-// SetRequestQuestion set question for DNS request.
+// SetRequestQuestionChecked sets question for DNS request as SetRequestQuestion, but returns ErrInvalidName and leaves
+// msg untouched if domain is rejected by NormalizeDomain, e.g. "a..b" with an empty label. The empty domain and "."
+// still ask for the root.
+func (msg *Message) SetRequestQuestionChecked(domain string, typ Type, class Class) error {
+	if domain != "" && domain != "." {
+		var err error
+		if domain, err = NormalizeDomain(domain); err != nil {
+			return err
+		}
+	}
+	msg.SetRequestQuestion(domain, typ, class)
+	return nil
+}
+
+// SetRequestQuestion set question for DNS request. A single trailing dot of domain is stripped,
+// and an empty domain or "." asks for the root.
 func (msg *Message) SetRequestQuestion(domain string, typ Type, class Class) {
 	// random head id
 	msg.Header.ID = uint16(cheaprandn(65536))
@@ -295,20 +1571,186 @@ func (msg *Message) SetRequestQuestion(domain string, typ Type, class Class) {
 
 	msg.Raw = append(msg.Raw[:0], header[:]...)
 
+	// a single trailing dot of fully qualified domain, the domain is not validated, see SetRequestQuestionChecked.
+	if n := len(domain); n != 0 && domain[n-1] == '.' {
+		domain = domain[:n-1]
+	}
+
 	// QNAME
-	msg.Raw = EncodeDomain(msg.Raw, domain)
-	msg.Question.Name = msg.Raw[len(header) : len(header)+len(domain)+2]
+	if domain == "" {
+		// the root
+		msg.Raw = append(msg.Raw, 0)
+	} else {
+		msg.Raw = EncodeDomain(msg.Raw, domain)
+	}
 	// QTYPE
 	msg.Raw = append(msg.Raw, byte(typ>>8), byte(typ))
 	msg.Question.Type = typ
 	// QCLASS
 	msg.Raw = append(msg.Raw, byte(class>>8), byte(class))
 	msg.Question.Class = class
+	msg.Question.Name = msg.Raw[len(header) : len(msg.Raw)-4]
 
 	// Domain
 	msg.Domain = append(msg.Domain[:0], domain...)
 }
 
+// SetQuestionFromRequest sets msg to a query forwarding req upstream, with a new random ID, the Opcode, RD and
+// CD bits and the question of req, and the OPT record of req with its options such as client subnet and cookie
+// intact. Other records of req are not copied, neither is an invalid OPT record.
+func (msg *Message) SetQuestionFromRequest(req *Message) {
+	msg.Header.ID = uint16(cheaprandn(65536))
+	if msg.Header.ID == req.Header.ID {
+		msg.Header.ID++
+	}
+
+	// QR = 0, Opcode, RD and CD are copied
+	//
+	//   0  1  2  3  4  5  6  7  8  9  A  B  C  D  E  F
+	// +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+	// |QR|   Opcode  |AA|TC|RD|RA|   Z    |   RCODE   |
+	// +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+	msg.Header.Flags = req.Header.Flags & 0b0111100100010000
+
+	msg.Header.QDCount = 1
+	msg.Header.ANCount = 0
+	msg.Header.NSCount = 0
+	msg.Header.ARCount = 0
+
+	r, off, err := req.optRecord()
+	if err == nil && off >= 0 {
+		msg.Header.ARCount = 1
+	}
+
+	msg.Raw = msg.AppendHeader(msg.Raw[:0])
+
+	// QNAME
+	msg.Raw = append(msg.Raw, req.Question.Name...)
+	msg.Question.Name = msg.Raw[12 : 12+len(req.Question.Name)]
+	// QTYPE
+	msg.Raw = append(msg.Raw, byte(req.Question.Type>>8), byte(req.Question.Type))
+	msg.Question.Type = req.Question.Type
+	// QCLASS
+	msg.Raw = append(msg.Raw, byte(req.Question.Class>>8), byte(req.Question.Class))
+	msg.Question.Class = req.Question.Class
+
+	// OPT, owned by root so RDATA starts after 1 + 10 bytes
+	if msg.Header.ARCount == 1 {
+		msg.Raw = append(msg.Raw, req.Raw[off:off+11+len(r.Data)]...)
+	}
+
+	// Domain
+	msg.Domain = append(msg.Domain[:0], req.Domain...)
+}
+
+// RefreshID sets a new random ID different from the current one, and only updates the ID bytes of Raw,
+// so that the same query can be sent again, e.g. on retries or to other upstreams.
+func (msg *Message) RefreshID() {
+	id := uint16(cheaprandn(65536))
+	if id == msg.Header.ID {
+		id++
+	}
+	msg.Header.ID = id
+
+	// ID
+	msg.Raw[0] = byte(id >> 8)
+	msg.Raw[1] = byte(id)
+}
+
+// Bytes returns a copy of the wire format message in Raw, which is not modified by later changes to msg.
+// ParseMessage of the result reproduces msg.
+func (msg *Message) Bytes() []byte {
+	return append([]byte(nil), msg.Raw...)
+}
+
+// AppendMessageNoAdditional appends the wire format of msg without the additional section to dst, i.e. the header
+// with ARCOUNT=0, the question, the answer and authority sections, e.g. to forward a response to a legacy client
+// without EDNS. Compression pointers are kept as they are, so they must not point into the additional section.
+func (msg *Message) AppendMessageNoAdditional(dst []byte) ([]byte, error) {
+	cut, err := msg.recordsEnd(int(msg.Header.ANCount) + int(msg.Header.NSCount))
+	if err != nil {
+		return dst, err
+	}
+
+	start := len(dst)
+	dst = append(dst, msg.Raw[:cut]...)
+
+	// ARCOUNT
+	dst[start+10] = 0
+	dst[start+11] = 0
+
+	return dst, nil
+}
+
+// AnswerOnly returns a new message with the header, the question and the answer section of msg, with the authority
+// and additional sections dropped and NSCount and ARCount set to 0, e.g. to cache only the answers. Raw of the result
+// does not refer to msg.Raw. It returns nil if msg is malformed.
+func (msg *Message) AnswerOnly() *Message {
+	cut, err := msg.recordsEnd(int(msg.Header.ANCount))
+	if err != nil {
+		return nil
+	}
+
+	m := &Message{Raw: append(make([]byte, 0, cut), msg.Raw[:cut]...)}
+
+	// NSCOUNT, ARCOUNT
+	m.Raw[8], m.Raw[9], m.Raw[10], m.Raw[11] = 0, 0, 0, 0
+
+	if ParseResponse(m, m.Raw, false) != nil {
+		return nil
+	}
+
+	return m
+}
+
+// recordsEnd returns the offset in Raw after the first n resource records.
+func (msg *Message) recordsEnd(n int) (int, error) {
+	cut := len(msg.Raw)
+	err := msg.walk(func(i, off, rdata, end int) bool {
+		if i == n {
+			cut = off
+			return false
+		}
+		return true
+	})
+	return cut, err
+}
+
+// LabelCount returns the number of labels in the question name without the root label, e.g. 3 for
+// "www.example.com" and 0 for the root, by walking the length octets of Question.Name without allocation.
+func (msg *Message) LabelCount() (n int) {
+	name := msg.Question.Name
+	for i := 0; i < len(name) && name[i] != 0 && name[i]&0b11000000 == 0; i += int(name[i]) + 1 {
+		n++
+	}
+	return
+}
+
+// PrefersTCP reports whether the query in Raw is longer than udpLimit bytes, so that a client sends it
+// over TCP directly instead of waiting for a truncated response, e.g. for large updates or EDNS options.
+func (msg *Message) PrefersTCP(udpLimit int) bool {
+	return len(msg.Raw) > udpLimit
+}
+
+// AppendHeader appends the 12 bytes wire format of msg.Header to dst, it is the same header as
+// the one written to Raw by SetRequestQuestion, SetResponse and SetResponseHeader.
+func (msg *Message) AppendHeader(dst []byte) []byte {
+	return append(dst,
+		// ID
+		byte(msg.Header.ID>>8), byte(msg.Header.ID),
+		// Flags
+		byte(msg.Header.Flags>>8), byte(msg.Header.Flags),
+		// QDCOUNT
+		byte(msg.Header.QDCount>>8), byte(msg.Header.QDCount),
+		// ANCOUNT
+		byte(msg.Header.ANCount>>8), byte(msg.Header.ANCount),
+		// NSCOUNT
+		byte(msg.Header.NSCount>>8), byte(msg.Header.NSCount),
+		// ARCOUNT
+		byte(msg.Header.ARCount>>8), byte(msg.Header.ARCount),
+	)
+}
+
 // SetResponseHeader sets QR=1, RCODE=rcode, ANCount=ancount then updates Raw.
 func (msg *Message) SetResponseHeader(rcode Rcode, ancount uint16) {
 	// QR = 1, RCODE = rcode
@@ -381,6 +1823,137 @@ func (msg *Message) SetResponseHeader(rcode Rcode, ancount uint16) {
 	header[11] = 0
 }
 
+// SetResponse sets msg to the response skeleton of req, the ID, Opcode, RD and the question are copied
+// from req, QR=1, RA=1, RCODE and the record counts are cleared. Callers then set RCODE and ANCount by SetResponseHeader
+// and append the answers by the Append*Record functions with msg as req.
+func (msg *Message) SetResponse(req *Message) {
+	msg.Header.ID = req.Header.ID
+	// QR = 1, RA = 1, Opcode and RD are copied
+	//
+	//   0  1  2  3  4  5  6  7  8  9  A  B  C  D  E  F
+	// +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+	// |QR|   Opcode  |AA|TC|RD|RA|   Z    |   RCODE   |
+	// +--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+	msg.Header.Flags = req.Header.Flags&0b0111100100000000 | 0b1000000010000000
+
+	msg.Header.QDCount = 1
+	msg.Header.ANCount = 0
+	msg.Header.NSCount = 0
+	msg.Header.ARCount = 0
+
+	header := [...]byte{
+		// ID
+		byte(msg.Header.ID >> 8), byte(msg.Header.ID),
+		// Flags
+		byte(msg.Header.Flags >> 8), byte(msg.Header.Flags),
+		// QDCOUNT, ANCOUNT, NSCOUNT, ARCOUNT
+		0, 1, 0, 0, 0, 0, 0, 0,
+	}
+
+	msg.Raw = append(msg.Raw[:0], header[:]...)
+
+	// QNAME
+	msg.Raw = append(msg.Raw, req.Question.Name...)
+	msg.Question.Name = msg.Raw[len(header) : len(header)+len(req.Question.Name)]
+	// QTYPE
+	msg.Raw = append(msg.Raw, byte(req.Question.Type>>8), byte(req.Question.Type))
+	msg.Question.Type = req.Question.Type
+	// QCLASS
+	msg.Raw = append(msg.Raw, byte(req.Question.Class>>8), byte(req.Question.Class))
+	msg.Question.Class = req.Question.Class
+
+	// Domain
+	msg.Domain = append(msg.Domain[:0], req.Domain...)
+}
+
+// SetRecursiveResponse sets msg to the response of a recursive resolver to req with RCODE=rcode, which is
+// the skeleton of SetResponse with RA=1, RD echoing req and the question kept also for error rcodes.
+func (msg *Message) SetRecursiveResponse(req *Message, rcode Rcode) {
+	msg.SetResponse(req)
+
+	// RA = 1, RD = req.RD, RCODE = rcode
+	msg.Header.Flags &= 0b1111111011110000
+	msg.Header.Flags |= req.Header.Flags&0b0000000100000000 | 0b0000000010000000 | Flags(rcode&0b1111)
+
+	// Flags
+	msg.Raw[2] = byte(msg.Header.Flags >> 8)
+	msg.Raw[3] = byte(msg.Header.Flags)
+}
+
+// SetAA sets the AA (Authoritative Answer) bit to v then updates Raw.
+func (msg *Message) SetAA(v bool) {
+	if v {
+		msg.Header.Flags |= 0b0000010000000000
+	} else {
+		msg.Header.Flags &= 0b1111101111111111
+	}
+
+	// Flags
+	msg.Raw[2] = byte(msg.Header.Flags >> 8)
+}
+
+// SOAData represents the RDATA of a SOA resource record, see RFC 1035 section 3.3.13.
+type SOAData struct {
+	MName   string
+	RName   string
+	Serial  uint32
+	Refresh uint32
+	Retry   uint32
+	Expire  uint32
+	Minimum uint32
+}
+
+// SetNoData sets msg to the authoritative NODATA response of req, with RCODE=NoError, AA=1, no answers and
+// the SOA record soa owned by soaOwner in the authority section for negative caching. The TTL of the SOA
+// record is soa.Minimum as RFC 2308 requires. A single trailing dot of the names is stripped.
+func (msg *Message) SetNoData(req *Message, soaOwner string, soa SOAData) {
+	msg.SetResponse(req)
+
+	msg.Header.Flags |= 0b0000010000000000
+	msg.Header.NSCount = 1
+
+	// Flags
+	msg.Raw[2] = byte(msg.Header.Flags >> 8)
+	msg.Raw[3] = byte(msg.Header.Flags)
+
+	// NSCOUNT
+	msg.Raw[8] = 0
+	msg.Raw[9] = 1
+
+	// NAME
+	msg.Raw = appendZoneName(msg.Raw, trimDot(soaOwner))
+	msg.Raw = append(msg.Raw,
+		// TYPE
+		byte(TypeSOA>>8), byte(TypeSOA),
+		// CLASS
+		byte(req.Question.Class>>8), byte(req.Question.Class),
+		// TTL
+		byte(soa.Minimum>>24), byte(soa.Minimum>>16), byte(soa.Minimum>>8), byte(soa.Minimum),
+		// RDLENGTH, set below
+		0, 0,
+	)
+
+	// RDATA
+	n := len(msg.Raw)
+	msg.Raw = appendZoneName(msg.Raw, trimDot(soa.MName))
+	msg.Raw = appendZoneName(msg.Raw, trimDot(soa.RName))
+	for _, v := range [...]uint32{soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minimum} {
+		msg.Raw = append(msg.Raw, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+
+	// RDLENGTH
+	msg.Raw[n-2] = byte((len(msg.Raw) - n) >> 8)
+	msg.Raw[n-1] = byte(len(msg.Raw) - n)
+}
+
+// trimDot strips a single trailing dot of the domain name s.
+func trimDot(s string) string {
+	if n := len(s); n > 0 && s[n-1] == '.' {
+		return s[:n-1]
+	}
+	return s
+}
+
 var msgPool = sync.Pool{
 	New: func() interface{} {
 		msg := new(Message)
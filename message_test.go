@@ -1,9 +1,16 @@
 package fastdns
 
 import (
+	"bytes"
 	"encoding/hex"
+	"errors"
+	"net"
+	"net/netip"
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseMessageOK(t *testing.T) {
@@ -110,10 +117,18 @@ func TestParseMessageError(t *testing.T) {
 			"00020100000000000000000002686b0470687573026c7500000100",
 			ErrInvalidHeader,
 		},
+		{
+			"000201000000000100000000c00c000100010000012c000401020408",
+			ErrInvalidHeader,
+		},
 		{
 			"00020100000100000000000002686b0470687573026c7500000100",
 			ErrInvalidQuestion,
 		},
+		{
+			"00020100000200000000000002686b0470687573026c750000010001c00c001c0001",
+			ErrInvalidHeader,
+		},
 	}
 
 	for _, c := range cases {
@@ -127,12 +142,258 @@ func TestParseMessageError(t *testing.T) {
 			t.Errorf("ParseMessage(%x) should error: %+v", payload, c.Error)
 		}
 	}
+
+	// ParseSingleQuestion tells multiple questions apart from the other header problems
+	payload, _ := hex.DecodeString("00020100000200000000000002686b0470687573026c750000010001c00c001c0001")
+	if err := ParseSingleQuestion(new(Message), payload, true); err != ErrMultipleQuestions {
+		t.Errorf("ParseSingleQuestion(%x) error got=%+v want=%+v", payload, err, ErrMultipleQuestions)
+	}
+	payload, _ = hex.DecodeString("00020100000000000000000002686b0470687573026c7500000100")
+	if err := ParseSingleQuestion(new(Message), payload, true); err != ErrInvalidHeader {
+		t.Errorf("ParseSingleQuestion(%x) error got=%+v want=%+v", payload, err, ErrInvalidHeader)
+	}
+
+	// callers checking for ErrInvalidHeader still see multiple questions
+	if !errors.Is(ErrMultipleQuestions, ErrInvalidHeader) || errors.Is(ErrInvalidHeader, ErrMultipleQuestions) {
+		t.Errorf("ErrMultipleQuestions shall wrap ErrInvalidHeader")
+	}
+}
+
+func TestSetQuestionTrailingDot(t *testing.T) {
+	var cases = []struct {
+		Domain string
+		QName  string
+		Name   string
+	}{
+		{"example.com.", "\x07example\x03com\x00", "example.com"},
+		{"example.com", "\x07example\x03com\x00", "example.com"},
+		{".", "\x00", ""},
+		{"", "\x00", ""},
+	}
+
+	for _, c := range cases {
+		req := new(Message)
+		req.SetRequestQuestion(c.Domain, TypeA, ClassINET)
+		if got, want := string(req.Raw[12:]), c.QName+"\x00\x01\x00\x01"; got != want {
+			t.Errorf("SetRequestQuestion(%q) Raw got=%q want=%q", c.Domain, got, want)
+		}
+		if got, want := string(req.Question.Name), c.QName; got != want {
+			t.Errorf("SetRequestQuestion(%q) Question.Name got=%q want=%q", c.Domain, got, want)
+		}
+		if got, want := string(req.Domain), c.Name; got != want {
+			t.Errorf("SetRequestQuestion(%q) Domain got=%q want=%q", c.Domain, got, want)
+		}
+
+		checked := new(Message)
+		if err := checked.SetRequestQuestionChecked(c.Domain, TypeA, ClassINET); err != nil || !bytes.Equal(checked.Raw[2:], req.Raw[2:]) {
+			t.Errorf("SetRequestQuestionChecked(%q) got=%x error=%+v want=%x", c.Domain, checked.Raw, err, req.Raw)
+		}
+	}
+
+	for _, domain := range []string{"a..b", ".example.com", "example.com..", strings.Repeat("a", 64) + ".com"} {
+		req := new(Message)
+		if err := req.SetRequestQuestionChecked(domain, TypeA, ClassINET); err != ErrInvalidName || req.Raw != nil {
+			t.Errorf("SetRequestQuestionChecked(%q) got=%x error=%+v want=%+v", domain, req.Raw, err, ErrInvalidName)
+		}
+	}
+}
+
+func TestParseAndValidate(t *testing.T) {
+	var cases = []struct {
+		Hex    string
+		Offset int
+		Error  error
+	}{
+		{
+			"000281800001000100000000076578616d706c65036f72670000010001c00c000100010000012c000401020408",
+			0,
+			nil,
+		},
+		{
+			"0001010000010000000000",
+			0,
+			ErrInvalidHeader,
+		},
+		{
+			"00020100000200000000000002686b0470687573026c750000010001c00c001c0001",
+			4,
+			ErrMultipleQuestions,
+		},
+		{
+			"00020100000100000000000002686b0470687573026c7500000100",
+			12,
+			ErrInvalidQuestion,
+		},
+		{
+			// QDCount is 0
+			"000201000000000000000000",
+			12,
+			ErrInvalidHeader,
+		},
+		{
+			// a trailing byte after the only answer
+			"000281800001000100000000076578616d706c65036f72670000010001c00c000100010000012c00040102040800",
+			45,
+			ErrInvalidHeader,
+		},
+		{
+			// a trailing byte after the question
+			"00020100000100000000000002686b0470687573026c75000001000100",
+			28,
+			ErrInvalidHeader,
+		},
+		{
+			// ANCount is 2 but only 1 answer
+			"000281800001000200000000076578616d706c65036f72670000010001c00c000100010000012c000401020408",
+			45,
+			ErrInvalidAnswer,
+		},
+		{
+			// CNAME with an out of range pointer
+			"000281800001000100000000076578616d706c65036f72670000010001c00c000500010000012c000603777777c0ff",
+			41,
+			ErrInvalidName,
+		},
+	}
+
+	for _, c := range cases {
+		payload, _ := hex.DecodeString(c.Hex)
+		err := ParseAndValidate(new(Message), payload)
+		if c.Error == nil {
+			if err != nil {
+				t.Errorf("ParseAndValidate(%s) error: %+v", c.Hex, err)
+			}
+			continue
+		}
+		var perr *ParseError
+		if !errors.As(err, &perr) || !errors.Is(err, c.Error) || perr.Offset != c.Offset {
+			t.Errorf("ParseAndValidate(%s) got=%v want offset %d: %v", c.Hex, err, c.Offset, c.Error)
+		}
+	}
+}
+
+func TestParseMessageMulti(t *testing.T) {
+	// www.example.org A IN, then mail.example.org AAAA IN compressed against the first question
+	payload, _ := hex.DecodeString("00028100000200000000000003777777076578616d706c65036f72670000010001046d61696cc010001c0001")
+
+	msg := new(Message)
+	questions, err := ParseMessageMulti(msg, nil, payload, true)
+	if err != nil {
+		t.Fatalf("ParseMessageMulti(%x) error: %+v", payload, err)
+	}
+	if len(questions) != 2 {
+		t.Fatalf("ParseMessageMulti(%x) got %d questions want 2", payload, len(questions))
+	}
+	if string(msg.Domain) != "www.example.org" || msg.Question.Type != TypeA || msg.Question.Class != ClassINET {
+		t.Errorf("ParseMessageMulti(%x) question got=%s %s %s", payload, msg.Domain, msg.Question.Type, msg.Question.Class)
+	}
+
+	var cases = []struct {
+		Domain string
+		Type   Type
+	}{
+		{"www.example.org", TypeA},
+		{"mail.example.org", TypeAAAA},
+	}
+	for i, c := range cases {
+		q := questions[i]
+		if got := msg.DecodeName(nil, q.Name); string(got) != c.Domain || q.Type != c.Type || q.Class != ClassINET {
+			t.Errorf("ParseMessageMulti(%x) question %d got=%s %s %s want=%s %s", payload, i, got, q.Type, q.Class, c.Domain, c.Type)
+		}
+	}
+
+	// the slice is reused and the single question fast path still applies
+	payload[5] = 0
+	questions, err = ParseMessageMulti(msg, questions[:0], payload[:33], false)
+	if err != ErrInvalidHeader || len(questions) != 0 {
+		t.Errorf("ParseMessageMulti(%x) got=%v err=%+v", payload[:33], questions, err)
+	}
+	payload[5] = 1
+	questions, err = ParseMessageMulti(msg, questions[:0], payload[:33], false)
+	if err != nil || len(questions) != 1 || string(msg.Domain) != "www.example.org" {
+		t.Errorf("ParseMessageMulti(%x) got=%v err=%+v", payload[:33], questions, err)
+	}
+	payload[5] = 3
+	if _, err = ParseMessageMulti(msg, nil, payload, false); err != ErrInvalidQuestion {
+		t.Errorf("ParseMessageMulti(%x) error got=%+v want=%+v", payload, err, ErrInvalidQuestion)
+	}
+}
+
+func TestMessageAppendMessageMulti(t *testing.T) {
+	// www.example.org A IN, then mail.example.org AAAA IN compressed against the first question,
+	// then an answer of www.example.org A 1.2.4.8
+	payload, _ := hex.DecodeString("00028180000200010000000003777777076578616d706c65036f72670000010001046d61696cc010001c0001c00c000100010000012c000401020408")
+
+	msg := new(Message)
+	questions, err := ParseMessageMulti(msg, nil, payload, true)
+	if err != nil {
+		t.Fatalf("ParseMessageMulti(%x) error: %+v", payload, err)
+	}
+
+	got, err := msg.AppendMessageMulti([]byte("prefix"), questions)
+	if err != nil {
+		t.Fatalf("AppendMessageMulti() error: %+v", err)
+	}
+	if !bytes.Equal(got[6:], payload) {
+		t.Errorf("AppendMessageMulti() got=%x want=%x", got[6:], payload)
+	}
+
+	// uncompressed names are compressed against the earlier questions
+	questions = []MessageQuestion{
+		{Name: EncodeDomain(nil, "www.example.org"), Type: TypeA, Class: ClassINET},
+		{Name: EncodeDomain(nil, "mail.example.org"), Type: TypeAAAA, Class: ClassINET},
+	}
+	if got, err = msg.AppendMessageMulti(nil, questions); err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("AppendMessageMulti(%v) got=(%x, %+v) want=%x", questions, got, err, payload)
+	}
+
+	questions = append(questions, MessageQuestion{Name: []byte("\x03www"), Type: TypeA, Class: ClassINET})
+	if _, err = msg.AppendMessageMulti(nil, questions); err != ErrInvalidQuestion {
+		t.Errorf("AppendMessageMulti() with a truncated name error got=%+v want=%+v", err, ErrInvalidQuestion)
+	}
+}
+
+func TestParseMessageNoQuestion(t *testing.T) {
+	// a response with QDCount=0 and one answer of example.org A 1.2.4.8
+	payload, _ := hex.DecodeString("000281800000000100000000076578616d706c65036f726700000100010000012c000401020408")
+
+	msg := new(Message)
+	if err := ParseMessage(msg, payload, true); err != ErrInvalidHeader {
+		t.Errorf("ParseMessage(%x) of a response without question error got=%+v want=%+v", payload, err, ErrInvalidHeader)
+	}
+	if err := ParseResponse(msg, payload, true); err != nil {
+		t.Fatalf("ParseResponse(%x) error: %+v", payload, err)
+	}
+	if len(msg.Domain) != 0 || msg.Question.Name != nil {
+		t.Errorf("ParseResponse(%x) shall have no question, got %+v", payload, msg.Question)
+	}
+
+	// a query still needs a question
+	payload[2] = 0x01
+	if err := ParseResponse(new(Message), payload, false); err != ErrInvalidHeader {
+		t.Errorf("ParseResponse(%x) of a query without question error got=%+v want=%+v", payload, err, ErrInvalidHeader)
+	}
+	payload[2] = 0x81
+
+	var records []MessageRecord
+	for r := range msg.Records {
+		records = append(records, r)
+	}
+	if len(records) != 1 || string(msg.DecodeName(nil, records[0].Name)) != "example.org" || records[0].TTL != 300 {
+		t.Fatalf("Records of a response without question got=%+v", records)
+	}
+	if ips := msg.AppendIPs(nil, false); len(ips) != 1 || ips[0] != netip.AddrFrom4([4]byte{1, 2, 4, 8}) {
+		t.Errorf("AppendIPs of a response without question got=%v", ips)
+	}
 }
 
 func TestSetQuestion(t *testing.T) {
 	req := AcquireMessage()
 	defer ReleaseMessage(req)
 
+	// pooled messages keep the flags of their last use
+	req.Header.Flags = 0
+
 	req.SetRequestQuestion("mail.google.com", TypeA, ClassINET)
 
 	if req.Header.ID == 0 {
@@ -176,6 +437,84 @@ func TestSetQuestion(t *testing.T) {
 	}
 }
 
+func TestQuestionLen(t *testing.T) {
+	var cases = []struct {
+		Hex    string
+		Length int
+		Error  error
+	}{
+		{"0001010000010000000000", 0, ErrInvalidHeader},
+		{"000101000001000000000000000002000100", 5, nil},
+		{"00020100000100000000000002686b0470687573026c750000010001", 16, nil},
+		{"00020100000100000000000002686b0470687573026c7500000100", 0, ErrInvalidQuestion},
+		{"000201000001000000000000c00c00010001", 0, ErrInvalidQuestion},
+	}
+
+	for _, c := range cases {
+		payload, _ := hex.DecodeString(c.Hex)
+		n, err := QuestionLen(payload)
+		if n != c.Length || err != c.Error {
+			t.Errorf("QuestionLen(%s) got=(%d, %v) want=(%d, %v)", c.Hex, n, err, c.Length, c.Error)
+		}
+	}
+}
+
+func TestAppendRcodeRaw(t *testing.T) {
+	var cases = []struct {
+		Query string
+		Rcode Rcode
+		Hex   string
+	}{
+		{
+			// hk.phus.lu A with an OPT record
+			"00020100000100000000000102686b0470687573026c750000010001" + "0000290200000000000000",
+			RcodeRefused,
+			"00028105000100000000000002686b0470687573026c750000010001",
+		},
+		{
+			"00030100000100000000000002686b0470687573026c750000010001",
+			RcodeServFail,
+			"00038102000100000000000002686b0470687573026c750000010001",
+		},
+	}
+
+	for _, c := range cases {
+		query, _ := hex.DecodeString(c.Query)
+		dst, err := AppendRcodeRaw(nil, query, c.Rcode)
+		if err != nil {
+			t.Errorf("AppendRcodeRaw(%s, %s) error: %+v", c.Query, c.Rcode, err)
+		}
+		if got, want := hex.EncodeToString(dst), c.Hex; got != want {
+			t.Errorf("AppendRcodeRaw(%s, %s) got=%s want=%s", c.Query, c.Rcode, got, want)
+		}
+	}
+}
+
+func TestMessageAppendDomainLower(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("WWW.Example.ORG", TypeA, ClassINET)
+
+	if got, want := string(msg.AppendDomainLower([]byte("domain="))), "domain=www.example.org"; got != want {
+		t.Errorf("AppendDomainLower got=%s want=%s", got, want)
+	}
+}
+
+func TestDecodeDomainInto(t *testing.T) {
+	var cases = []struct {
+		QName  string
+		Domain string
+	}{
+		{"\x02lu\x00", "lu"},
+		{"\x03www\x07example\x03org\x00", "www.example.org"},
+	}
+
+	for _, c := range cases {
+		if got, want := string(decodeDomainInto([]byte("domain="), []byte(c.QName))), "domain="+c.Domain; got != want {
+			t.Errorf("decodeDomainInto(%q) got=%q want=%q", c.QName, got, want)
+		}
+	}
+}
+
 func TestDecodeName(t *testing.T) {
 	payload, _ := hex.DecodeString("8e5281800001000200000000047632657803636f6d0000020001c00c000200010000545f0014036b696d026e730a636c6f7564666c617265c011c00c000200010000545f000704746f6464c02a")
 
@@ -192,51 +531,1547 @@ func TestDecodeName(t *testing.T) {
 	}
 }
 
-func BenchmarkParseMessage(b *testing.B) {
-	payload, _ := hex.DecodeString("00020100000100000000000002686b0470687573026c750000010001")
-	var msg Message
+func TestDecodeNameInvalid(t *testing.T) {
+	// a question of 4 labels of 63 bytes each, which is 257 bytes in wire format
+	label := "3f" + strings.Repeat("61", 63)
+	payload, _ := hex.DecodeString("000181800001000000000000" + label + label + label + label + "0000010001")
 
-	for i := 0; i < b.N; i++ {
-		if err := ParseMessage(&msg, payload, false); err != nil {
-			b.Errorf("ParseMessage(%+v) error: %+v", payload, err)
+	msg := new(Message)
+	msg.Raw = payload
+
+	var cases = []struct {
+		Name  []byte
+		Error error
+	}{
+		// expands past 255 bytes
+		{[]byte{0xc0, 0x0c}, ErrInvalidName},
+		// skips the first label, 193 bytes
+		{[]byte{0xc0, 0x4c}, nil},
+		// out of range
+		{[]byte{0xc1, 0x00}, ErrInvalidName},
+		// truncated
+		{[]byte{0x03, 'w', 'w'}, ErrInvalidName},
+	}
+
+	for _, c := range cases {
+		if _, err := msg.decodeName(nil, c.Name); err != c.Error {
+			t.Errorf("decodeName(%x) error got=%v want=%v", c.Name, err, c.Error)
 		}
 	}
-}
 
-func BenchmarkSetQuestion(b *testing.B) {
-	req := AcquireMessage()
-	defer ReleaseMessage(req)
+	// 127 and 128 single byte labels
+	for n, want := range map[int]error{127: nil, 128: ErrInvalidName} {
+		name := append(bytes.Repeat([]byte{0x01, 'a'}, n), 0x00)
+		if _, err := msg.decodeName(nil, name); err != want {
+			t.Errorf("decodeName of %d labels error got=%v want=%v", n, err, want)
+		}
+	}
 
-	for i := 0; i < b.N; i++ {
-		req.SetRequestQuestion("mail.google.com", TypeA, ClassINET)
+	// a pointer to itself
+	loop := &Message{Raw: append(make([]byte, 12), 0xc0, 0x0c)}
+	if _, err := loop.decodeName(nil, []byte{0xc0, 0x0c}); err != ErrInvalidName {
+		t.Errorf("decodeName of a pointer loop error got=%v want=%v", err, ErrInvalidName)
+	}
+
+	if got := msg.DecodeName([]byte("prefix"), []byte{0xc1, 0x00}); string(got) != "prefix" {
+		t.Errorf("DecodeName of invalid name shall return dst unchanged, got=%q", got)
 	}
 }
 
-func BenchmarkSetResponseHeader(b *testing.B) {
-	req := AcquireMessage()
-	defer ReleaseMessage(req)
+func TestMessageContentHash(t *testing.T) {
+	newResponse := func(ttl uint32, ips ...netip.Addr) *Message {
+		msg := AcquireMessage()
+		msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+		msg.SetResponseHeader(RcodeNoError, uint16(len(ips)))
+		msg.Raw = AppendHOSTRecord(msg.Raw, msg, ttl, ips)
+		return msg
+	}
 
-	req.SetRequestQuestion("mail.google.com", TypeA, ClassINET)
+	ip1, ip2 := netip.AddrFrom4([4]byte{1, 1, 1, 1}), netip.AddrFrom4([4]byte{8, 8, 8, 8})
 
-	for i := 0; i < b.N; i++ {
-		req.SetResponseHeader(RcodeNoError, 4)
+	a := newResponse(300, ip1, ip2)
+	defer ReleaseMessage(a)
+	b := newResponse(60, ip2, ip1)
+	defer ReleaseMessage(b)
+	c := newResponse(300, ip1)
+	defer ReleaseMessage(c)
+
+	if a.Header.ID == b.Header.ID {
+		b.Header.ID++
+	}
+
+	if got, want := a.ContentHash(), b.ContentHash(); got != want {
+		t.Errorf("ContentHash of reordered responses got=%x want=%x", got, want)
+	}
+
+	if a.ContentHash() == c.ContentHash() {
+		t.Errorf("ContentHash of different responses shall not be equal")
 	}
 }
 
-func BenchmarkDecodeName(b *testing.B) {
-	payload, _ := hex.DecodeString("8e5281800001000200000000047632657803636f6d0000020001c00c000200010000545f0014036b696d026e730a636c6f7564666c617265c011c00c000200010000545f000704746f6464c02a")
+func TestMessageAppendIPs(t *testing.T) {
+	msg := AcquireMessage()
+	defer ReleaseMessage(msg)
 
-	resp := AcquireMessage()
-	defer ReleaseMessage(resp)
+	msg.SetRequestQuestion("example.org", TypeAAAA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 2)
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, []netip.Addr{
+		netip.MustParseAddr("::ffff:1.2.3.4"),
+		netip.MustParseAddr("2001:4860:4860::8888"),
+	})
 
-	err := ParseMessage(resp, payload, true)
+	var cases = []struct {
+		Unmap bool
+		IPs   []netip.Addr
+	}{
+		{false, []netip.Addr{netip.MustParseAddr("::ffff:1.2.3.4"), netip.MustParseAddr("2001:4860:4860::8888")}},
+		{true, []netip.Addr{netip.MustParseAddr("1.2.3.4"), netip.MustParseAddr("2001:4860:4860::8888")}},
+	}
+
+	for _, c := range cases {
+		if got, want := msg.AppendIPs(nil, c.Unmap), c.IPs; !reflect.DeepEqual(got, want) {
+			t.Errorf("AppendIPs(unmap=%v) got=%v want=%v", c.Unmap, got, want)
+		}
+		if got, ok := msg.FirstIP(c.Unmap); !ok || got != c.IPs[0] {
+			t.Errorf("FirstIP(unmap=%v) got=%v want=%v", c.Unmap, got, c.IPs[0])
+		}
+	}
+}
+
+func TestMessageRRset(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("www.example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 3)
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")})
+	msg.Raw = AppendCNAMERecord(msg.Raw, msg, 300, []string{"alias.example.net"}, nil)
+
+	rrset, err := msg.RRset([]byte("WWW.Example.org"), TypeA, nil)
 	if err != nil {
-		b.Errorf("ParseMessage(%+v) error: %+v", payload, err)
+		t.Fatalf("RRset() error: %+v", err)
+	}
+	if len(rrset) != 2 || !bytes.Equal(rrset[0], []byte{192, 0, 2, 1}) || !bytes.Equal(rrset[1], []byte{192, 0, 2, 2}) {
+		t.Errorf("RRset(A) got=%x want=[c0000201 c0000202]", rrset)
 	}
 
-	var dst [256]byte
-	name := []byte("\x04todd\xc0\x2a")
-	for i := 0; i < b.N; i++ {
-		resp.DecodeName(dst[:0], name)
+	if rrset, _ := msg.RRset([]byte("www.example.org"), TypeAAAA, nil); len(rrset) != 0 {
+		t.Errorf("RRset(AAAA) got=%x want=[]", rrset)
+	}
+	if rrset, _ := msg.RRset([]byte("example.org"), TypeA, nil); len(rrset) != 0 {
+		t.Errorf("RRset(example.org) got=%x want=[]", rrset)
+	}
+}
+
+func TestMessageTypeANY(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeANY, ClassINET)
+	if msg.HasType(TypeANY) {
+		t.Errorf("HasType(ANY) without answers got=true want=false")
+	}
+
+	msg.SetResponseHeader(RcodeNoError, 3)
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")})
+	msg.Raw = AppendMXRecord(msg.Raw, msg, 300, []net.MX{{Host: "mail.example.org", Pref: 10}})
+
+	if !msg.HasType(TypeANY) {
+		t.Errorf("HasType(ANY) got=false want=true")
+	}
+	if msg.HasType(TypeTXT) {
+		t.Errorf("HasType(TXT) got=true want=false")
+	}
+
+	rrset, err := msg.RRset([]byte("example.org"), TypeANY, nil)
+	if err != nil || len(rrset) != 3 || len(rrset[0]) != 4 || len(rrset[1]) != 16 {
+		t.Errorf("RRset(ANY) got=(%x, %+v) want 3 records", rrset, err)
+	}
+	if rrset, _ := msg.RRset([]byte("www.example.org"), TypeANY, nil); len(rrset) != 0 {
+		t.Errorf("RRset(www.example.org, ANY) got=%x want=[]", rrset)
+	}
+
+	if ips := msg.AppendIPs(nil, false); len(ips) != 2 {
+		t.Errorf("AppendIPs() of ANY got=%v want 2 addresses", ips)
+	}
+}
+
+func TestMessageFinalAnswers(t *testing.T) {
+	var cases = []struct {
+		Hex string
+		IPs []netip.Addr
+	}{
+		{
+			// www.example.org CNAME cdn.example.net, cdn.example.net CNAME EDGE.example.net, other.example.net A 8.8.8.8, edge.example.net A 1.2.4.8
+			"00028180000100040000000003777777076578616d706c65036f72670000010001c00c000500010000012c00110363646e076578616d706c65036e657400c02d000500010000012c00070445444745c031056f74686572c031000100010000012c000408080808c04a000100010000012c000401020408",
+			[]netip.Addr{netip.AddrFrom4([4]byte{1, 2, 4, 8})},
+		},
+		{
+			// www.example.org CNAME cdn.example.net without records
+			"00028180000100010000000003777777076578616d706c65036f72670000010001c00c000500010000012c00110363646e076578616d706c65036e657400",
+			nil,
+		},
+		{
+			// www.example.org CNAME cdn.example.net, cdn.example.net CNAME www.example.org
+			"00028180000100020000000003777777076578616d706c65036f72670000010001c00c000500010000012c00110363646e076578616d706c65036e657400c02d000500010000012c0002c00c",
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		payload, _ := hex.DecodeString(c.Hex)
+		msg := new(Message)
+		if err := ParseMessage(msg, payload, true); err != nil {
+			t.Fatalf("ParseMessage(%s) error: %+v", c.Hex, err)
+		}
+		ips, err := msg.FinalAnswers(nil)
+		if err != nil {
+			t.Errorf("FinalAnswers(%s) error: %+v", c.Hex, err)
+		}
+		if !reflect.DeepEqual(ips, c.IPs) {
+			t.Errorf("FinalAnswers(%s) got=%v want=%v", c.Hex, ips, c.IPs)
+		}
+	}
+}
+
+func TestMessageSOASerial(t *testing.T) {
+	msg := new(Message)
+
+	// SOA in the authority section
+	req := new(Message)
+	req.SetRequestQuestion("www.example.org", TypeAAAA, ClassINET)
+	msg.SetNoData(req, "example.org", SOAData{MName: "ns1.example.org", RName: "admin.example.org", Serial: 2024010101, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 300})
+	if serial, ok := msg.SOASerial(); !ok || serial != 2024010101 {
+		t.Errorf("SOASerial() of NODATA got=(%d, %v) want=(2024010101, true)", serial, ok)
+	}
+
+	// SOA in the answer section
+	msg.SetRequestQuestion("example.org", TypeSOA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 1)
+	msg.Raw = AppendSOARecord(msg.Raw, msg, 300, net.NS{Host: "ns1.example.org"}, net.NS{Host: "admin.example.org"}, 42, 7200, 3600, 1209600, 300)
+	if serial, ok := msg.SOASerial(); !ok || serial != 42 {
+		t.Errorf("SOASerial() of answer got=(%d, %v) want=(42, true)", serial, ok)
+	}
+
+	// truncated SOA RDATA
+	msg.SetResponseHeader(RcodeNoError, 1)
+	msg.Raw = append(msg.Raw, 0xc0, 0x0c, 0x00, byte(TypeSOA), 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x06, 0xc0, 0x0c, 0xc0, 0x0c, 0x00, 0x00)
+	if serial, ok := msg.SOASerial(); ok {
+		t.Errorf("SOASerial() of truncated SOA got=(%d, %v) want=(0, false)", serial, ok)
+	}
+
+	// no SOA
+	if serial, ok := mockMessage().SOASerial(); ok {
+		t.Errorf("SOASerial() without SOA got=(%d, %v) want=(0, false)", serial, ok)
+	}
+}
+
+func TestSetNoData(t *testing.T) {
+	for _, owner := range []string{"example.org", "example.org."} {
+		req := new(Message)
+		req.SetRequestQuestion("www.example.org", TypeAAAA, ClassINET)
+
+		msg := new(Message)
+		msg.SetNoData(req, owner, SOAData{MName: "ns1.example.org.", RName: "admin.example.org", Serial: 2024010101, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 300})
+
+		resp := new(Message)
+		if err := ParseAndValidate(resp, msg.Raw); err != nil {
+			t.Fatalf("SetNoData(%s) %x error: %+v", owner, msg.Raw, err)
+		}
+
+		if resp.Header.ID != req.Header.ID || resp.Header.Flags.QR() != 1 || resp.Header.Flags.AA() != 1 {
+			t.Errorf("SetNoData(%s) header got=%+v", owner, resp.Header)
+		}
+		if got, want := resp.Header.Flags.Rcode(), RcodeNoError; got != want {
+			t.Errorf("SetNoData(%s) Rcode got=%s want=%s", owner, got, want)
+		}
+		if resp.Header.ANCount != 0 || resp.Header.NSCount != 1 || resp.Header.ARCount != 0 {
+			t.Errorf("SetNoData(%s) counts got=%d/%d/%d want=0/1/0", owner, resp.Header.ANCount, resp.Header.NSCount, resp.Header.ARCount)
+		}
+		if string(resp.Domain) != "www.example.org" || resp.Question.Type != TypeAAAA {
+			t.Errorf("SetNoData(%s) question got=%s %s", owner, resp.Domain, resp.Question.Type)
+		}
+
+		var n int
+		for r := range resp.Records {
+			n++
+			if r.Type != TypeSOA || r.TTL != 300 {
+				t.Errorf("SetNoData(%s) record got=%s TTL %d want=SOA TTL 300", owner, r.Type, r.TTL)
+			}
+			if got, want := string(resp.DecodeName(nil, r.Name)), "example.org"; got != want {
+				t.Errorf("SetNoData(%s) SOA owner got=%s want=%s", owner, got, want)
+			}
+			mname, rname, serial, _, _, _, minimum, err := resp.DecodeSOA(r.Data)
+			if err != nil || string(mname) != "ns1.example.org" || string(rname) != "admin.example.org" || serial != 2024010101 || minimum != 300 {
+				t.Errorf("SetNoData(%s) SOA got=%s %s %d %d error=%+v", owner, mname, rname, serial, minimum, err)
+			}
+		}
+		if n != 1 {
+			t.Errorf("SetNoData(%s) records got=%d want=1", owner, n)
+		}
+	}
+}
+
+func TestMessageSinkholeA(t *testing.T) {
+	msg := AcquireMessage()
+	defer ReleaseMessage(msg)
+
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 3)
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, []netip.Addr{
+		netip.AddrFrom4([4]byte{1, 1, 1, 1}),
+		netip.AddrFrom4([4]byte{8, 8, 8, 8}),
+		netip.AddrFrom4([4]byte{9, 9, 9, 9}),
+	})
+
+	sinkhole := netip.AddrFrom4([4]byte{0, 0, 0, 0})
+	msg.SinkholeA(sinkhole)
+
+	ips := msg.AppendIPs(nil, false)
+	if len(ips) != 3 {
+		t.Fatalf("SinkholeA changed the number of answers: %v", ips)
+	}
+	for _, ip := range ips {
+		if ip != sinkhole {
+			t.Errorf("SinkholeA got=%s want=%s", ip, sinkhole)
+		}
+	}
+	for r := range msg.Records {
+		if r.TTL != 300 {
+			t.Errorf("SinkholeA changed TTL got=%d want=%d", r.TTL, 300)
+		}
+	}
+}
+
+func TestMessageSynthesize64(t *testing.T) {
+	msg := AcquireMessage()
+	defer ReleaseMessage(msg)
+
+	msg.SetRequestQuestion("www.example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 3)
+	msg.Raw = AppendCNAMERecord(msg.Raw, msg, 300, []string{"example.org"}, []netip.Addr{
+		netip.AddrFrom4([4]byte{192, 0, 2, 1}),
+		netip.AddrFrom4([4]byte{198, 51, 100, 7}),
+	})
+
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	err := msg.Synthesize64(netip.MustParsePrefix("64:ff9b::/96"), resp)
+	if err != nil {
+		t.Fatalf("Synthesize64 error: %+v", err)
+	}
+
+	if got, want := resp.Question.Type, TypeAAAA; got != want {
+		t.Errorf("Synthesize64 question type got=%s want=%s", got, want)
+	}
+	if got, want := resp.Header.ANCount, uint16(3); got != want {
+		t.Errorf("Synthesize64 ANCount got=%d want=%d", got, want)
+	}
+
+	want := []netip.Addr{netip.MustParseAddr("64:ff9b::c000:201"), netip.MustParseAddr("64:ff9b::c633:6407")}
+	if got := resp.AppendIPs(nil, false); !reflect.DeepEqual(got, want) {
+		t.Errorf("Synthesize64 addresses got=%v want=%v", got, want)
+	}
+
+	if err := msg.Synthesize64(netip.MustParsePrefix("64:ff9b::/80"), resp); err != ErrInvalidPrefix {
+		t.Errorf("Synthesize64 with /80 prefix shall return ErrInvalidPrefix, got %+v", err)
+	}
+}
+
+func TestMessageHasType(t *testing.T) {
+	msg := AcquireMessage()
+	defer ReleaseMessage(msg)
+
+	msg.SetRequestQuestion("www.example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 2)
+	msg.Raw = AppendCNAMERecord(msg.Raw, msg, 300, []string{"example.org"}, []netip.Addr{netip.AddrFrom4([4]byte{1, 1, 1, 1})})
+
+	var cases = []struct {
+		Type Type
+		Has  bool
+	}{
+		{TypeCNAME, true},
+		{TypeA, true},
+		{TypeAAAA, false},
+		{TypeMX, false},
+	}
+
+	for _, c := range cases {
+		if got, want := msg.HasType(c.Type), c.Has; got != want {
+			t.Errorf("HasType(%s) got=%v want=%v", c.Type, got, want)
+		}
+	}
+}
+
+func TestMessageRawRecords(t *testing.T) {
+	payload, _ := hex.DecodeString("8e5281800001000200000000047632657803636f6d0000020001c00c000200010000545f0014036b696d026e730a636c6f7564666c617265c011c00c000200010000545f000704746f6464c02a")
+
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	err := ParseMessage(resp, payload, true)
+	if err != nil {
+		t.Errorf("ParseMessage(%+v) error: %+v", payload, err)
+	}
+
+	var records []string
+	err = resp.RawRecords(SectionAnswer, func(raw []byte) bool {
+		records = append(records, hex.EncodeToString(raw))
+		return true
+	})
+	if err != nil {
+		t.Errorf("RawRecords(SectionAnswer) error: %+v", err)
+	}
+
+	want := []string{
+		"c00c000200010000545f0014036b696d026e730a636c6f7564666c617265c011",
+		"c00c000200010000545f000704746f6464c02a",
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("RawRecords(SectionAnswer) got=%v want=%v", records, want)
+	}
+
+	err = resp.RawRecords(SectionAdditional, func(raw []byte) bool {
+		t.Errorf("RawRecords(SectionAdditional) shall not yield %x", raw)
+		return true
+	})
+	if err != nil {
+		t.Errorf("RawRecords(SectionAdditional) error: %+v", err)
+	}
+}
+
+func TestMessageOpaqueRecords(t *testing.T) {
+	// AVC and NINFO answers of example.org
+	payload, _ := hex.DecodeString("000181800001000200000000076578616d706c65036f72670001020001" +
+		"c00c010200010000012c000b0a6170703d6665646f7261" +
+		"c00c003800010000012c00060568656c6c6f")
+
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	err := ParseMessage(resp, payload, true)
+	if err != nil {
+		t.Fatalf("ParseMessage(%x) error: %+v", payload, err)
+	}
+
+	var cases = []struct {
+		Type Type
+		Data string
+	}{
+		{TypeAVC, "\x0aapp=fedora"},
+		{TypeNINFO, "\x05hello"},
+	}
+
+	var i int
+	for r := range resp.Records {
+		if r.Type != cases[i].Type || string(r.Data) != cases[i].Data {
+			t.Errorf("Records got=(%s, %q) want=(%s, %q)", r.Type, r.Data, cases[i].Type, cases[i].Data)
+		}
+		i++
+	}
+
+	i = 0
+	err = resp.RawRecords(SectionAnswer, func(raw []byte) bool {
+		typ, data, err := RawRDATA(raw)
+		if err != nil || typ != cases[i].Type || string(data) != cases[i].Data {
+			t.Errorf("RawRDATA(%x) got=(%s, %q, %v) want=(%s, %q)", raw, typ, data, err, cases[i].Type, cases[i].Data)
+		}
+		i++
+		return true
+	})
+	if err != nil || i != len(cases) {
+		t.Errorf("RawRecords(SectionAnswer) got %d records, error: %+v", i, err)
+	}
+}
+
+func TestMessageReferralHasGlue(t *testing.T) {
+	var cases = []struct {
+		Hex  string
+		Glue bool
+	}{
+		{
+			// example.org NS ns1.example.org, ns2.example.org, NS1.example.org A 1.2.4.8, ns3.example.org A 1.2.4.8
+			"00028000000100000002000203777777076578616d706c65036f72670000010001c01000020001000151800011036e7331076578616d706c65036f726700c01000020001000151800006036e7332c010034e5331c0100001000100015180000401020408036e7333c0100001000100015180000401020408",
+			true,
+		},
+		{
+			// example.org NS ns1.example.org, ns2.example.org, ns3.example.org A 1.2.4.8 twice
+			"00028000000100000002000203777777076578616d706c65036f72670000010001c01000020001000151800011036e7331076578616d706c65036f726700c01000020001000151800006036e7332c010036e7333c0100001000100015180000401020408036e7333c0100001000100015180000401020408",
+			false,
+		},
+	}
+
+	for _, c := range cases {
+		payload, _ := hex.DecodeString(c.Hex)
+		msg := new(Message)
+		if err := ParseMessage(msg, payload, true); err != nil {
+			t.Fatalf("ParseMessage(%s) error: %+v", c.Hex, err)
+		}
+		if got, want := msg.ReferralHasGlue(), c.Glue; got != want {
+			t.Errorf("ReferralHasGlue(%s) got=%v want=%v", c.Hex, got, want)
+		}
+	}
+}
+
+func TestClosestEnclosingZone(t *testing.T) {
+	var referrals []*Message
+	for _, s := range []string{
+		// org NS a0.org-servers.net
+		"0002800000010000000100000377777703737562076578616d706c65036f72670000010001036f726700000200010001518000140261300b6f72672d73657276657273036e657400",
+		// EXAMPLE.org NS ns1.example.org
+		"0002800000010000000100000377777703737562076578616d706c65036f72670000010001074558414d504c45036f72670000020001000151800011036e7331076578616d706c65036f726700",
+		// ample.org NS ns1.ample.org
+		"0002800000010000000100000377777703737562076578616d706c65036f7267000001000105616d706c65036f7267000002000100015180000f036e733105616d706c65036f726700",
+	} {
+		payload, _ := hex.DecodeString(s)
+		msg := new(Message)
+		if err := ParseMessage(msg, payload, true); err != nil {
+			t.Fatalf("ParseMessage(%s) error: %+v", s, err)
+		}
+		referrals = append(referrals, msg)
+	}
+
+	var cases = []struct {
+		QName string
+		Zone  []byte
+	}{
+		{"www.sub.example.org", []byte("EXAMPLE.org")},
+		{"www.example.com", nil},
+		{"org", []byte("org")},
+	}
+
+	for _, c := range cases {
+		if got, want := ClosestEnclosingZone([]byte(c.QName), referrals), c.Zone; !reflect.DeepEqual(got, want) {
+			t.Errorf("ClosestEnclosingZone(%s) got=%q want=%q", c.QName, got, want)
+		}
+	}
+}
+
+func TestMessageIsInternetClass(t *testing.T) {
+	var cases = []struct {
+		Class    Class
+		Internet bool
+	}{
+		{ClassINET, true},
+		{ClassCHAOS, false},
+		{ClassHESIOD, false},
+		{ClassANY, false},
+	}
+
+	msg := new(Message)
+	for _, c := range cases {
+		msg.SetRequestQuestion("version.bind", TypeTXT, c.Class)
+		if got, want := msg.IsInternetClass(), c.Internet; got != want {
+			t.Errorf("IsInternetClass(%s) got=%v want=%v", c.Class, got, want)
+		}
+	}
+}
+
+func TestMessageMatchAnswerCaseTo(t *testing.T) {
+	// wWw.ExAmPlE.oRg A
+	query, _ := hex.DecodeString("00020100000100000000000003775777074578416d506c45036f52670000010001")
+	// www.example.org A, WWW.EXAMPLE.ORG A 1.2.4.8, 0xc00c A 8.8.8.8
+	payload, _ := hex.DecodeString("00028180000100020000000003777777076578616d706c65036f7267000001000103575757074558414d504c45034f524700000100010000012c000401020408c00c000100010000012c000408080808")
+
+	msg := new(Message)
+	if err := ParseMessage(msg, payload, true); err != nil {
+		t.Fatalf("ParseMessage(%x) error: %+v", payload, err)
+	}
+
+	msg.MatchAnswerCaseTo(query)
+
+	var owners []string
+	for r := range msg.Records {
+		owners = append(owners, string(msg.DecodeName(nil, r.Name)))
+	}
+	if want := []string{"wWw.ExAmPlE.oRg", "wWw.ExAmPlE.oRg"}; !reflect.DeepEqual(owners, want) {
+		t.Errorf("MatchAnswerCaseTo got owners=%q want=%q", owners, want)
+	}
+}
+
+func TestMessageBytes(t *testing.T) {
+	var cases = []struct {
+		Type    Type
+		ANCount uint16
+		Records func(dst []byte, req *Message) []byte
+	}{
+		{TypeA, 2, func(dst []byte, req *Message) []byte {
+			return AppendHOSTRecord(dst, req, 300, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")})
+		}},
+		{TypeAAAA, 1, func(dst []byte, req *Message) []byte {
+			return AppendHOSTRecord(dst, req, 300, []netip.Addr{netip.MustParseAddr("2001:db8::1")})
+		}},
+		{TypeCNAME, 2, func(dst []byte, req *Message) []byte {
+			return AppendCNAMERecord(dst, req, 300, []string{"alias.example.net"}, []netip.Addr{netip.MustParseAddr("192.0.2.1")})
+		}},
+		{TypeMX, 1, func(dst []byte, req *Message) []byte {
+			return AppendMXRecord(dst, req, 300, []net.MX{{Host: "mail.example.org", Pref: 10}})
+		}},
+		{TypeNS, 2, func(dst []byte, req *Message) []byte {
+			return AppendNSRecord(dst, req, 300, []net.NS{{Host: "ns1.example.org"}, {Host: "ns2.example.org"}})
+		}},
+		{TypeSRV, 1, func(dst []byte, req *Message) []byte {
+			return AppendSRVRecord(dst, req, 300, []net.SRV{{Target: "sip.example.org", Port: 5060, Priority: 10, Weight: 5}})
+		}},
+		{TypeTXT, 1, func(dst []byte, req *Message) []byte {
+			return AppendTXTRecord(dst, req, 300, strings.Repeat("v=spf1 -all ", 30))
+		}},
+		{TypeSOA, 1, func(dst []byte, req *Message) []byte {
+			return AppendSOARecord(dst, req, 300, net.NS{Host: "ns1.example.org"}, net.NS{Host: "hostmaster.example.org"}, 1, 7200, 3600, 1209600, 60)
+		}},
+	}
+
+	for _, c := range cases {
+		msg := new(Message)
+		msg.SetRequestQuestion("example.org", c.Type, ClassINET)
+		msg.SetResponseHeader(RcodeNoError, c.ANCount)
+		msg.Raw = c.Records(msg.Raw, msg)
+
+		b := msg.Bytes()
+		got := new(Message)
+		if err := ParseMessage(got, b, true); err != nil {
+			t.Errorf("ParseMessage(Bytes(%s)) error: %+v", c.Type, err)
+			continue
+		}
+		if got.Header != msg.Header || got.Question.Type != msg.Question.Type || got.Question.Class != msg.Question.Class ||
+			!bytes.Equal(got.Question.Name, msg.Question.Name) || !bytes.Equal(got.Domain, msg.Domain) || !bytes.Equal(got.Raw, msg.Raw) {
+			t.Errorf("ParseMessage(Bytes(%s)) got=%+v want=%+v", c.Type, got, msg)
+		}
+		if diff := DiffMessages(got, msg); len(diff) != 0 {
+			t.Errorf("ParseMessage(Bytes(%s)) diff: %v", c.Type, diff)
+		}
+
+		// the copy and Raw do not alias in either direction
+		raw := append([]byte(nil), msg.Raw...)
+		b = msg.Bytes()
+		for i := range b {
+			b[i] ^= 0xff
+		}
+		if !bytes.Equal(msg.Raw, raw) {
+			t.Errorf("Bytes(%s) modifying the copy changed Raw to %x", c.Type, msg.Raw)
+		}
+		b = msg.Bytes()
+		for i := range msg.Raw {
+			msg.Raw[i] ^= 0xff
+		}
+		if !bytes.Equal(b, raw) {
+			t.Errorf("Bytes(%s) modifying Raw changed the copy to %x", c.Type, b)
+		}
+	}
+}
+
+func TestMessageAppendMessageNoAdditional(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 1)
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, []netip.Addr{netip.MustParseAddr("192.0.2.1")})
+	want := msg.Bytes()
+	want[11] = 0
+
+	msg.Raw = AppendOPTRecord(msg.Raw, 1232, 0, 0, true, []EDNS0Option{{Code: 10, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8}}})
+	msg.Header.ARCount = 1
+	msg.Raw[11] = 1
+
+	got, err := msg.AppendMessageNoAdditional([]byte("prefix"))
+	if err != nil {
+		t.Fatalf("AppendMessageNoAdditional() error: %+v", err)
+	}
+	if string(got[:6]) != "prefix" || !bytes.Equal(got[6:], want) {
+		t.Errorf("AppendMessageNoAdditional() got=%x want=%x", got[6:], want)
+	}
+
+	resp := new(Message)
+	if err := ParseAndValidate(resp, got[6:]); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", got[6:], err)
+	}
+	if resp.Header.ANCount != 1 || resp.Header.ARCount != 0 {
+		t.Errorf("AppendMessageNoAdditional() header got=%+v", resp.Header)
+	}
+
+	// the message itself is untouched
+	if msg.Raw[11] != 1 || len(msg.Raw) == len(got)-6 {
+		t.Errorf("AppendMessageNoAdditional() modified the message")
+	}
+}
+
+func TestMessageLabelCount(t *testing.T) {
+	var cases = []struct {
+		Domain string
+		Count  int
+	}{
+		{"www.example.com", 3},
+		{"example.com.", 2},
+		{"com", 1},
+		{".", 0},
+		{"", 0},
+	}
+
+	for _, c := range cases {
+		msg := new(Message)
+		msg.SetRequestQuestion(c.Domain, TypeA, ClassINET)
+		if got := msg.LabelCount(); got != c.Count {
+			t.Errorf("LabelCount(%q) got=%d want=%d", c.Domain, got, c.Count)
+		}
+	}
+
+	if got := new(Message).LabelCount(); got != 0 {
+		t.Errorf("LabelCount() of empty message got=%d want=0", got)
+	}
+}
+
+func TestMessageAnswerOnly(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 2)
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")})
+	want := msg.Bytes()
+	want[7] = 2
+
+	// authority and additional sections
+	msg.Raw = AppendNSRecord(msg.Raw, msg, 300, []net.NS{{Host: "ns1.example.org"}})
+	msg.Raw = AppendOPTRecord(msg.Raw, 1232, 0, 0, false, nil)
+	msg.Header.NSCount, msg.Header.ARCount = 1, 1
+	_ = msg.AppendHeader(msg.Raw[:0])
+	if err := ParseAndValidate(new(Message), msg.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", msg.Raw, err)
+	}
+
+	got := msg.AnswerOnly()
+	if got == nil {
+		t.Fatalf("AnswerOnly(%x) got nil", msg.Raw)
+	}
+	if !bytes.Equal(got.Raw, want) {
+		t.Errorf("AnswerOnly() got=%x want=%x", got.Raw, want)
+	}
+	if got.Header.ID != msg.Header.ID || got.Header.ANCount != 2 || got.Header.NSCount != 0 || got.Header.ARCount != 0 {
+		t.Errorf("AnswerOnly() header got=%+v", got.Header)
+	}
+	if string(got.Domain) != "example.org" || got.Question.Type != TypeA {
+		t.Errorf("AnswerOnly() question got=%s %s", got.Domain, got.Question.Type)
+	}
+	if err := ParseAndValidate(new(Message), got.Raw); err != nil {
+		t.Errorf("ParseAndValidate(%x) error: %+v", got.Raw, err)
+	}
+
+	msg.Raw = msg.Raw[:len(want)-1]
+	if got := msg.AnswerOnly(); got != nil {
+		t.Errorf("AnswerOnly() of malformed message got=%x want=nil", got.Raw)
+	}
+}
+
+func TestMessageIsUnicastResponse(t *testing.T) {
+	var cases = []struct {
+		Hex     string
+		Class   Class
+		Unicast bool
+	}{
+		// _services._dns-sd._udp.local PTR QU
+		{"000000000001000000000000095f7365727669636573075f646e732d7364045f756470056c6f63616c00000c8001", ClassINET, true},
+		// _services._dns-sd._udp.local PTR QM
+		{"000000000001000000000000095f7365727669636573075f646e732d7364045f756470056c6f63616c00000c0001", ClassINET, false},
+		// a response without question
+		{"000084000000000000000000", 0, false},
+	}
+
+	for _, c := range cases {
+		payload, _ := hex.DecodeString(c.Hex)
+		msg := new(Message)
+		if err := ParseResponse(msg, payload, true); err != nil {
+			t.Fatalf("ParseResponse(%s) error: %+v", c.Hex, err)
+		}
+		if msg.MDNSClass() != c.Class || msg.IsUnicastResponse() != c.Unicast {
+			t.Errorf("ParseResponse(%s) got class=%s unicast=%v want class=%s unicast=%v", c.Hex, msg.MDNSClass(), msg.IsUnicastResponse(), c.Class, c.Unicast)
+		}
+		// the raw QCLASS is kept, so that it is echoed as is
+		if c.Unicast && msg.Question.Class != c.Class|0x8000 {
+			t.Errorf("ParseResponse(%s) Question.Class got=%d want=%d", c.Hex, msg.Question.Class, c.Class|0x8000)
+		}
+	}
+}
+
+func TestMessageKnownAnswers(t *testing.T) {
+	// host.local A QM with the known answers 192.168.1.10 and 192.168.1.11, the latter with the cache-flush bit
+	payload, _ := hex.DecodeString("000000000001000200000000" +
+		"04686f7374056c6f63616c0000010001" +
+		"c00c00010001000000780004c0a8010a" +
+		"c00c00018001000000780004c0a8010b")
+
+	msg := new(Message)
+	if err := ParseMessage(msg, payload, true); err != nil {
+		t.Fatalf("ParseMessage(%x) error: %+v", payload, err)
+	}
+
+	type known struct {
+		Name  string
+		Type  Type
+		Class Class
+		TTL   uint32
+		Addr  string
+	}
+	var got []known
+	err := msg.KnownAnswers(func(name []byte, typ Type, class Class, ttl uint32, data []byte) bool {
+		addr, _ := ParseAddr(typ, data)
+		got = append(got, known{string(msg.DecodeName(nil, name)), typ, class, ttl, addr.String()})
+		return true
+	})
+	if err != nil {
+		t.Fatalf("KnownAnswers(%x) error: %+v", payload, err)
+	}
+	want := []known{
+		{"host.local", TypeA, ClassINET, 120, "192.168.1.10"},
+		{"host.local", TypeA, ClassINET, 120, "192.168.1.11"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("KnownAnswers(%x) got=%+v want=%+v", payload, got, want)
+	}
+
+	n := 0
+	_ = msg.KnownAnswers(func([]byte, Type, Class, uint32, []byte) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("KnownAnswers() shall stop when f returns false, got %d calls", n)
+	}
+
+	msg.Raw = msg.Raw[:len(msg.Raw)-1]
+	if err := msg.KnownAnswers(func([]byte, Type, Class, uint32, []byte) bool { return true }); err != ErrInvalidAnswer {
+		t.Errorf("KnownAnswers() of truncated message error got=%+v want=%+v", err, ErrInvalidAnswer)
+	}
+}
+
+func TestMessagePrefersTCP(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	if msg.PrefersTCP(512) {
+		t.Errorf("PrefersTCP(512) of a %d bytes query got=true want=false", len(msg.Raw))
+	}
+
+	// EDNS padding option of RFC 7830
+	msg.Raw = AppendOPTRecord(msg.Raw, 1232, 0, 0, false, []EDNS0Option{{Code: 12, Data: make([]byte, 512)}})
+	msg.Header.ARCount = 1
+	msg.Raw[11] = 1
+	if !msg.PrefersTCP(512) {
+		t.Errorf("PrefersTCP(512) of a %d bytes query got=false want=true", len(msg.Raw))
+	}
+	if msg.PrefersTCP(1232) {
+		t.Errorf("PrefersTCP(1232) of a %d bytes query got=true want=false", len(msg.Raw))
+	}
+}
+
+func TestMessageAppendHeader(t *testing.T) {
+	msg := mockMessage()
+	if got, want := msg.AppendHeader(nil), msg.Raw[:12]; !bytes.Equal(got, want) {
+		t.Errorf("AppendHeader() got=%x want=%x", got, want)
+	}
+
+	msg = new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.Header.Flags = 0b1010111110000011 // QR, Opcode=5, AA, TC, RD, RA, RCODE=3
+	msg.Header.ANCount, msg.Header.NSCount, msg.Header.ARCount = 1, 2, 3
+
+	header := msg.AppendHeader(nil)
+	if len(header) != 12 {
+		t.Fatalf("AppendHeader() length got=%d want=12", len(header))
+	}
+
+	payload := append(header, msg.Raw[12:]...)
+	got := new(Message)
+	if err := ParseMessage(got, payload, true); err != nil {
+		t.Fatalf("ParseMessage(%x) error: %+v", payload, err)
+	}
+	if got.Header != msg.Header {
+		t.Errorf("AppendHeader() round trip got=%+v want=%+v", got.Header, msg.Header)
+	}
+	if got.Header.Flags.Opcode() != OpcodeUpdate || got.Header.Flags.Rcode() != RcodeNXDomain || got.Header.Flags.TC() != 1 {
+		t.Errorf("AppendHeader() flags got=%016b", got.Header.Flags)
+	}
+}
+
+func TestMessageOpcodeMatches(t *testing.T) {
+	req := new(Message)
+	req.SetRequestQuestion("example.org", TypeA, ClassINET)
+
+	resp := new(Message)
+	resp.SetResponse(req)
+	if !resp.OpcodeMatches(req) {
+		t.Errorf("OpcodeMatches() of a QUERY response got=false want=true")
+	}
+
+	resp.Header.Flags = resp.Header.Flags&0b1000011111111111 | Flags(OpcodeNotify)<<11
+	if resp.OpcodeMatches(req) {
+		t.Errorf("OpcodeMatches() of a NOTIFY response to a QUERY got=true want=false")
+	}
+
+	if req.OpcodeMatches(req) {
+		t.Errorf("OpcodeMatches() of a request got=true want=false")
+	}
+}
+
+func TestMessageRecursionRefused(t *testing.T) {
+	var cases = []struct {
+		RD, RA bool
+		Want   bool
+	}{
+		{true, false, true},
+		{true, true, false},
+		{false, false, false},
+		{false, true, false},
+	}
+
+	for _, c := range cases {
+		req := new(Message)
+		req.SetRequestQuestion("example.org", TypeA, ClassINET)
+		if !c.RD {
+			req.Header.Flags &^= 0b0000000100000000
+		}
+
+		resp := new(Message)
+		resp.SetResponse(req)
+		if !c.RA {
+			resp.Header.Flags &^= 0b0000000010000000
+		}
+
+		if got := resp.RecursionRefused(req); got != c.Want {
+			t.Errorf("RecursionRefused(RD=%v, RA=%v) got=%v want=%v", c.RD, c.RA, got, c.Want)
+		}
+	}
+}
+
+func TestSameQuestion(t *testing.T) {
+	var cases = []struct {
+		Domain1 string
+		Type1   Type
+		Domain2 string
+		Type2   Type
+		Same    bool
+	}{
+		{"www.example.org", TypeA, "WWW.Example.ORG", TypeA, true},
+		{"www.example.org", TypeA, "www.example.org", TypeAAAA, false},
+		{"www.example.org", TypeA, "www.example.com", TypeA, false},
+	}
+
+	a, b := new(Message), new(Message)
+	for _, c := range cases {
+		a.SetRequestQuestion(c.Domain1, c.Type1, ClassINET)
+		b.SetRequestQuestion(c.Domain2, c.Type2, ClassINET)
+		if got, want := SameQuestion(a, b), c.Same; got != want {
+			t.Errorf("SameQuestion(%s %s, %s %s) got=%v want=%v", c.Domain1, c.Type1, c.Domain2, c.Type2, got, want)
+		}
+	}
+}
+
+func TestMessageTTLs(t *testing.T) {
+	// A 1.2.4.8 in the answer section, SOA in the authority section and OPT in the additional section
+	payload, _ := hex.DecodeString("000281800001000100010001076578616d706c65036f72670000010001c00c000100010000012c000401020408c00c0006000100000e100038036e7331076578616d706c65036f7267000561646d696e076578616d706c65036f7267000000000100001c2000000e10000151800000012c00002904d0000080000000")
+
+	msg := new(Message)
+	if err := ParseMessage(msg, payload, true); err != nil {
+		t.Fatalf("ParseMessage error: %+v", err)
+	}
+
+	ttls, err := msg.TTLs(make([]uint32, 0, 4))
+	if err != nil {
+		t.Fatalf("TTLs error: %+v", err)
+	}
+	if want := []uint32{300, 3600}; !reflect.DeepEqual(ttls, want) {
+		t.Errorf("TTLs got=%v want=%v", ttls, want)
+	}
+
+	// the OPT record keeps its EDNS flags
+	if err := msg.CapTTLs(600); err != nil {
+		t.Fatalf("CapTTLs error: %+v", err)
+	}
+	if ttls, _ = msg.TTLs(ttls[:0]); !reflect.DeepEqual(ttls, []uint32{300, 600}) {
+		t.Errorf("CapTTLs(600) got=%v want=%v", ttls, []uint32{300, 600})
+	}
+	if opt, _, err := msg.optRecord(); err != nil || opt.TTL != 0x8000 {
+		t.Errorf("CapTTLs(600) got OPT ttl=%x error=%+v", opt.TTL, err)
+	}
+}
+
+func TestMessageDecodeCNAME(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("www.example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 2)
+	msg.Raw = append(msg.Raw,
+		// www.example.org CNAME cdn + pointer to example.org in the question
+		0xc0, 0x0c, 0x00, byte(TypeCNAME), 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x06, 3, 'c', 'd', 'n', 0xc0, 0x10,
+		// www.example.org CNAME pointer to example.org in the question
+		0xc0, 0x0c, 0x00, byte(TypeCNAME), 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x02, 0xc0, 0x10,
+	)
+
+	var targets []string
+	for r := range msg.Records {
+		targets = append(targets, string(msg.DecodeCNAME(nil, r.Data)))
+	}
+	if want := []string{"cdn.example.org", "example.org"}; !slices.Equal(targets, want) {
+		t.Errorf("DecodeCNAME() got=%q want=%q", targets, want)
+	}
+
+	// pointer past the message
+	if got := msg.DecodeCNAME([]byte("x"), []byte{0xc0, 0xff}); string(got) != "x" {
+		t.Errorf("DecodeCNAME() of a bad pointer got=%q want=%q", got, "x")
+	}
+}
+
+func TestMessageDecodeMX(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeMX, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 2)
+	msg.Raw = append(msg.Raw,
+		// example.org MX 10 mail + pointer to example.org in the question
+		0xc0, 0x0c, 0x00, byte(TypeMX), 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x09, 0x00, 0x0a, 4, 'm', 'a', 'i', 'l', 0xc0, 0x0c,
+		// example.org MX 20 pointer to example.org in the question
+		0xc0, 0x0c, 0x00, byte(TypeMX), 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x04, 0x00, 0x14, 0xc0, 0x0c,
+	)
+
+	var got []net.MX
+	for r := range msg.Records {
+		pref, exchange := msg.DecodeMX(nil, r.Data)
+		got = append(got, net.MX{Host: string(exchange), Pref: pref})
+	}
+	if want := []net.MX{{Host: "mail.example.org", Pref: 10}, {Host: "example.org", Pref: 20}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeMX() got=%v want=%v", got, want)
+	}
+
+	if pref, exchange := msg.DecodeMX(nil, []byte{0x00, 0x0a}); pref != 0 || exchange != nil {
+		t.Errorf("DecodeMX() of a short RDATA got=(%d, %q) want=(0, nil)", pref, exchange)
+	}
+}
+
+func TestMessageDecodeSRV(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("_sip._tcp.example.org", TypeSRV, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 1)
+	msg.Raw = append(msg.Raw,
+		// SRV 10 60 5060 sip + pointer to example.org in the question
+		0xc0, 0x0c, 0x00, byte(TypeSRV), 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x0c,
+		0x00, 0x0a, 0x00, 0x3c, 0x13, 0xc4, 3, 's', 'i', 'p', 0xc0, 0x16,
+	)
+
+	var got []net.SRV
+	for r := range msg.Records {
+		priority, weight, port, target := msg.DecodeSRV(nil, r.Data)
+		got = append(got, net.SRV{Target: string(target), Port: port, Priority: priority, Weight: weight})
+	}
+	if want := []net.SRV{{Target: "sip.example.org", Port: 5060, Priority: 10, Weight: 60}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DecodeSRV() got=%v want=%v", got, want)
+	}
+
+	if _, _, _, target := msg.DecodeSRV(nil, []byte{0, 10, 0, 60, 0x13, 0xc4}); target != nil {
+		t.Errorf("DecodeSRV() of a short RDATA got=%q want=nil", target)
+	}
+}
+
+func TestMessageDecodeSOA(t *testing.T) {
+	msg := new(Message)
+	req := new(Message)
+	req.SetRequestQuestion("www.example.org", TypeAAAA, ClassINET)
+	msg.SetNoData(req, "example.org", SOAData{MName: "ns1.example.org", RName: "admin.example.org", Serial: 2024010101, Refresh: 7200, Retry: 3600, Expire: 1209600, Minimum: 60})
+
+	for r := range msg.Records {
+		mname, rname, serial, refresh, retry, expire, minimum, err := msg.DecodeSOA(r.Data)
+		if err != nil {
+			t.Fatalf("DecodeSOA(%x) error: %+v", r.Data, err)
+		}
+		if string(mname) != "ns1.example.org" || string(rname) != "admin.example.org" {
+			t.Errorf("DecodeSOA(%x) names got=(%s, %s)", r.Data, mname, rname)
+		}
+		if got, want := []uint32{serial, refresh, retry, expire, minimum}, []uint32{2024010101, 7200, 3600, 1209600, 60}; !slices.Equal(got, want) {
+			t.Errorf("DecodeSOA(%x) got=%v want=%v", r.Data, got, want)
+		}
+	}
+
+	// MNAME and RNAME compressed to the question name
+	data := append([]byte{0xc0, 0x0c, 0xc0, 0x10}, make([]byte, 20)...)
+	data[len(data)-1] = 60
+	if mname, rname, _, _, _, _, minimum, err := msg.DecodeSOA(data); err != nil || string(mname) != "www.example.org" || string(rname) != "example.org" || minimum != 60 {
+		t.Errorf("DecodeSOA(%x) got=(%s, %s, %d, %+v)", data, mname, rname, minimum, err)
+	}
+
+	if _, _, _, _, _, _, _, err := msg.DecodeSOA(data[:len(data)-1]); err != ErrInvalidAnswer {
+		t.Errorf("DecodeSOA() of a short RDATA got=%+v want=%+v", err, ErrInvalidAnswer)
+	}
+}
+
+func TestParseAddr(t *testing.T) {
+	var cases = []struct {
+		Type Type
+		Data string
+		IP   netip.Addr
+		OK   bool
+	}{
+		{TypeA, "c0000201", netip.MustParseAddr("192.0.2.1"), true},
+		{TypeAAAA, "20010db8000000000000000000000001", netip.MustParseAddr("2001:db8::1"), true},
+		{TypeAAAA, "00000000000000000000ffffc0000201", netip.MustParseAddr("::ffff:192.0.2.1"), true},
+		{TypeAAAA, "c0000201", netip.Addr{}, false},
+		{TypeA, "20010db8000000000000000000000001", netip.Addr{}, false},
+		{TypeCNAME, "c0000201", netip.Addr{}, false},
+	}
+
+	for _, c := range cases {
+		data, _ := hex.DecodeString(c.Data)
+		if ip, ok := ParseAddr(c.Type, data); ip != c.IP || ok != c.OK {
+			t.Errorf("ParseAddr(%s, %s) got=(%v, %v) want=(%v, %v)", c.Type, c.Data, ip, ok, c.IP, c.OK)
+		}
+	}
+}
+
+func TestLooksLikeDNS(t *testing.T) {
+	var cases = []struct {
+		Hex  string
+		Want bool
+	}{
+		// query of hk.phus.lu A
+		{"00020100000100000000000002686b0470687573026c750000010001", true},
+		// response of hk.phus.lu A
+		{"00028180000100010000000002686b0470687573026c750000010001c00c000100010000012b0004771c56be", true},
+		// error response without question
+		{"000281830000000000000000", true},
+		// random bytes
+		{"8f3a5c17e2b94d06a1f7c3e85b20d69e47f10c3b", false},
+		// short header
+		{"0002010000010000000000", false},
+		// two questions
+		{"00020100000200000000000002686b0470687573026c750000010001", false},
+		// Opcode 3
+		{"00021900000100000000000002686b0470687573026c750000010001", false},
+		// Z bit
+		{"00020140000100000000000002686b0470687573026c750000010001", false},
+		// answer count past the payload
+		{"00028180000100050000000002686b0470687573026c750000010001c00c000100010000012b0004771c56be", false},
+	}
+
+	for _, c := range cases {
+		payload, _ := hex.DecodeString(c.Hex)
+		if got := LooksLikeDNS(payload); got != c.Want {
+			t.Errorf("LooksLikeDNS(%s) got=%v want=%v", c.Hex, got, c.Want)
+		}
+	}
+}
+
+func TestMessageAnswerRDATA(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("www.example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 3)
+	msg.Raw = AppendCNAMERecord(msg.Raw, msg, 300, []string{"alias.example.net"}, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.0.2.2")})
+
+	var cases = []struct {
+		Index int
+		Type  Type
+		Data  string
+		OK    bool
+	}{
+		{0, TypeCNAME, "05616c696173076578616d706c65036e657400", true},
+		{1, TypeA, "c0000201", true},
+		{2, TypeA, "c0000202", true},
+		{3, 0, "", false},
+		{-1, 0, "", false},
+	}
+
+	for _, c := range cases {
+		typ, data, ok := msg.AnswerRDATA(c.Index)
+		if typ != c.Type || hex.EncodeToString(data) != c.Data || ok != c.OK {
+			t.Errorf("AnswerRDATA(%d) got=(%s, %x, %v) want=(%s, %s, %v)", c.Index, typ, data, ok, c.Type, c.Data, c.OK)
+		}
+	}
+
+	// a truncated message
+	msg.Raw = msg.Raw[:len(msg.Raw)-2]
+	if _, _, ok := msg.AnswerRDATA(2); ok {
+		t.Errorf("AnswerRDATA(2) of a truncated message got ok=true want=false")
+	}
+}
+
+func TestMessageAnswerTTL(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 2)
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, []netip.Addr{netip.AddrFrom4([4]byte{1, 1, 1, 1}), netip.AddrFrom4([4]byte{8, 8, 8, 8})})
+
+	if !msg.SetAnswerTTL(1, 60) {
+		t.Fatalf("SetAnswerTTL(1) shall return true")
+	}
+	for i, want := range []uint32{300, 60} {
+		if got, ok := msg.AnswerTTL(i); !ok || got != want {
+			t.Errorf("AnswerTTL(%d) got=%d,%v want=%d", i, got, ok, want)
+		}
+	}
+	if ttls, _ := msg.TTLs(nil); !reflect.DeepEqual(ttls, []uint32{300, 60}) {
+		t.Errorf("SetAnswerTTL(1) shall update Raw, got TTLs=%v", ttls)
+	}
+
+	for _, i := range []int{-1, 2} {
+		if _, ok := msg.AnswerTTL(i); ok {
+			t.Errorf("AnswerTTL(%d) shall return false", i)
+		}
+		if msg.SetAnswerTTL(i, 60) {
+			t.Errorf("SetAnswerTTL(%d) shall return false", i)
+		}
+	}
+}
+
+func TestMessageShouldPrefetch(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 2)
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 300, []netip.Addr{netip.AddrFrom4([4]byte{1, 1, 1, 1})})
+	msg.Raw = AppendHOSTRecord(msg.Raw, msg, 100, []netip.Addr{netip.AddrFrom4([4]byte{8, 8, 8, 8})})
+
+	if got, want := msg.MinTTL(), uint32(100); got != want {
+		t.Errorf("MinTTL got=%d want=%d", got, want)
+	}
+
+	storedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got, want := msg.ExpiresAt(storedAt), storedAt.Add(100*time.Second); !got.Equal(want) {
+		t.Errorf("ExpiresAt got=%v want=%v", got, want)
+	}
+
+	var cases = []struct {
+		Elapsed  time.Duration
+		Prefetch bool
+	}{
+		{50 * time.Second, false},
+		{95 * time.Second, true},
+		{120 * time.Second, true},
+	}
+
+	for _, c := range cases {
+		if got, want := msg.ShouldPrefetch(storedAt, storedAt.Add(c.Elapsed), 0.9), c.Prefetch; got != want {
+			t.Errorf("ShouldPrefetch(%v, 0.9) got=%v want=%v", c.Elapsed, got, want)
+		}
+	}
+}
+
+func TestMessageSetRecursiveResponse(t *testing.T) {
+	for _, rd := range []Flags{0, 0b0000000100000000} {
+		req := new(Message)
+		req.SetRequestQuestion("example.org", TypeA, ClassINET)
+		req.Header.Flags = req.Header.Flags&0b1111111011111111 | rd
+		req.Raw[2] = byte(req.Header.Flags >> 8)
+
+		resp := new(Message)
+		resp.SetRecursiveResponse(req, RcodeServFail)
+
+		got := new(Message)
+		if err := ParseMessage(got, resp.Raw, true); err != nil {
+			t.Fatalf("ParseMessage(%x) error: %+v", resp.Raw, err)
+		}
+		if got.Header.ID != req.Header.ID || got.Header.Flags.QR() != 1 || got.Header.Flags.RA() != 1 ||
+			got.Header.Flags.RD() != req.Header.Flags.RD() || got.Header.Flags.Rcode() != RcodeServFail {
+			t.Errorf("SetRecursiveResponse(RD=%d) header got=%016b", req.Header.Flags.RD(), got.Header.Flags)
+		}
+		if !SameQuestion(got, req) {
+			t.Errorf("SetRecursiveResponse(RD=%d) question got=%s %s", req.Header.Flags.RD(), got.Domain, got.Question.Type)
+		}
+	}
+}
+
+func TestMessageSetResponse(t *testing.T) {
+	req := mockMessage()
+	defer ReleaseMessage(req)
+
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	resp.SetResponse(req)
+	resp.SetResponseHeader(RcodeNoError, 1)
+	resp.Raw = AppendHOST1Record(resp.Raw, resp, 300, netip.AddrFrom4([4]byte{1, 2, 4, 8}))
+
+	if got, want := hex.EncodeToString(resp.Raw), "00028180000100010000000002686b0470687573026c750000010001c00c000100010000012c000401020408"; got != want {
+		t.Errorf("SetResponse got=%s want=%s", got, want)
+	}
+	if resp.Header.ID != req.Header.ID || string(resp.Domain) != "hk.phus.lu" || resp.Question.Type != TypeA {
+		t.Errorf("SetResponse got=%+v", resp)
+	}
+}
+
+func TestMessageSetAA(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 0)
+
+	msg.SetAA(true)
+	if got := msg.Header.Flags.AA(); got != 1 {
+		t.Errorf("SetAA(true) Header.Flags.AA() got=%d want=1", got)
+	}
+	if got, want := msg.Raw[2], byte(0x85); got != want {
+		t.Errorf("SetAA(true) Raw[2] got=%#x want=%#x", got, want)
+	}
+
+	msg.SetAA(false)
+	if got, want := msg.Raw[2], byte(0x81); got != want {
+		t.Errorf("SetAA(false) Raw[2] got=%#x want=%#x", got, want)
+	}
+}
+
+func TestMessageCanonicalize(t *testing.T) {
+	var cases = []struct {
+		Hex1 string
+		Hex2 string
+	}{
+		{
+			// www.Example.ORG CNAME Example.ORG, A 1.1.1.1, A 2.2.2.2
+			"12348180000100030000000003777777074578616d706c65034f52470000010001c00c0005000100000e100002c010c010000100010000012c000401010101c010000100010000012c000402020202",
+			// www.example.org with answers reordered and compressed differently
+			"abcd8180000100030000000003777777076578616d706c65036f72670000010001076578616d706c65036f726700000100010000012c000402020202c021000100010000012c000401010101c00c0005000100000e100002c021",
+		},
+	}
+
+	for _, c := range cases {
+		var msgs [2]*Message
+		for i, s := range []string{c.Hex1, c.Hex2} {
+			payload, err := hex.DecodeString(s)
+			if err != nil {
+				t.Fatalf("hex.DecodeString(%q) error: %+v", s, err)
+			}
+			msgs[i] = new(Message)
+			if err := ParseMessage(msgs[i], payload, true); err != nil {
+				t.Fatalf("ParseMessage(%q) error: %+v", s, err)
+			}
+			msgs[i].Canonicalize()
+		}
+		if got, want := hex.EncodeToString(msgs[1].Raw), hex.EncodeToString(msgs[0].Raw); got != want {
+			t.Errorf("Canonicalize got=%s want=%s", got, want)
+		}
+		if msgs[0].Header.ID != 0 || string(msgs[0].Domain) != "www.example.org" {
+			t.Errorf("Canonicalize got ID=%d Domain=%q", msgs[0].Header.ID, msgs[0].Domain)
+		}
+		if ips := msgs[0].AppendIPs(nil, false); len(ips) != 2 || ips[0].String() != "1.1.1.1" || ips[1].String() != "2.2.2.2" {
+			t.Errorf("Canonicalize got ips=%v", ips)
+		}
+	}
+}
+
+func TestMessageRefreshID(t *testing.T) {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeA, ClassINET)
+	raw := append([]byte(nil), msg.Raw...)
+
+	for range 100 {
+		id := msg.Header.ID
+		msg.RefreshID()
+		if msg.Header.ID == id {
+			t.Fatalf("RefreshID shall change the ID %d", id)
+		}
+		if got := uint16(msg.Raw[0])<<8 | uint16(msg.Raw[1]); got != msg.Header.ID {
+			t.Errorf("RefreshID Raw ID got=%d want=%d", got, msg.Header.ID)
+		}
+		if !bytes.Equal(msg.Raw[2:], raw[2:]) {
+			t.Errorf("RefreshID shall only change the ID bytes, got=%x want=%x", msg.Raw, raw)
+		}
+	}
+}
+
+func BenchmarkParseMessage(b *testing.B) {
+	payload, _ := hex.DecodeString("00020100000100000000000002686b0470687573026c750000010001")
+	var msg Message
+
+	for i := 0; i < b.N; i++ {
+		if err := ParseMessage(&msg, payload, false); err != nil {
+			b.Errorf("ParseMessage(%+v) error: %+v", payload, err)
+		}
+	}
+}
+
+func mockResponse(typ Type, ancount uint16, appendRecords func(dst []byte, req *Message) []byte) []byte {
+	msg := new(Message)
+	msg.SetRequestQuestion("www.example.org", typ, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, ancount)
+	return appendRecords(msg.Raw, msg)
+}
+
+var (
+	benchIP4 = netip.AddrFrom4([4]byte{1, 2, 4, 8})
+	benchIP6 = netip.MustParseAddr("2001:db8::1")
+	benchIPs = func() (ips []netip.Addr) {
+		for i := range 20 {
+			ips = append(ips, netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}))
+		}
+		return
+	}()
+)
+
+var benchResponses = map[string][]byte{
+	"A": mockResponse(TypeA, 1, func(dst []byte, req *Message) []byte {
+		return AppendHOST1Record(dst, req, 300, benchIP4)
+	}),
+	"AAAA": mockResponse(TypeAAAA, 1, func(dst []byte, req *Message) []byte {
+		return AppendHOST1Record(dst, req, 300, benchIP6)
+	}),
+	"CNAMEChain": mockResponse(TypeA, 4, func(dst []byte, req *Message) []byte {
+		return AppendCNAMERecord(dst, req, 300, []string{"cdn.example.net", "edge.example.net"}, []netip.Addr{benchIP4, benchIP4})
+	}),
+	"LargeTXT": mockResponse(TypeTXT, 1, func(dst []byte, req *Message) []byte {
+		return AppendTXTRecord(dst, req, 300, strings.Repeat("v=spf1 include:_spf.example.org ", 7))
+	}),
+	"20Records": mockResponse(TypeA, 20, func(dst []byte, req *Message) []byte {
+		return AppendHOSTRecord(dst, req, 300, benchIPs)
+	}),
+}
+
+func benchmarkParseMessage(b *testing.B, name string) {
+	payload := benchResponses[name]
+	var msg Message
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := ParseMessage(&msg, payload, false); err != nil {
+			b.Errorf("ParseMessage(%x) error: %+v", payload, err)
+		}
+	}
+}
+
+func BenchmarkParseMessage_A(b *testing.B)          { benchmarkParseMessage(b, "A") }
+func BenchmarkParseMessage_AAAA(b *testing.B)       { benchmarkParseMessage(b, "AAAA") }
+func BenchmarkParseMessage_CNAMEChain(b *testing.B) { benchmarkParseMessage(b, "CNAMEChain") }
+func BenchmarkParseMessage_LargeTXT(b *testing.B)   { benchmarkParseMessage(b, "LargeTXT") }
+func BenchmarkParseMessage_20Records(b *testing.B)  { benchmarkParseMessage(b, "20Records") }
+
+func benchmarkAppendMessage(b *testing.B, typ Type, ancount uint16, appendRecords func(dst []byte, req *Message) []byte) {
+	msg := new(Message)
+	msg.SetRequestQuestion("www.example.org", typ, ClassINET)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		msg.SetResponseHeader(RcodeNoError, ancount)
+		msg.Raw = appendRecords(msg.Raw, msg)
+	}
+}
+
+func BenchmarkAppendMessage_A(b *testing.B) {
+	benchmarkAppendMessage(b, TypeA, 1, func(dst []byte, req *Message) []byte {
+		return AppendHOST1Record(dst, req, 300, benchIP4)
+	})
+}
+
+func BenchmarkAppendMessage_AAAA(b *testing.B) {
+	benchmarkAppendMessage(b, TypeAAAA, 1, func(dst []byte, req *Message) []byte {
+		return AppendHOST1Record(dst, req, 300, benchIP6)
+	})
+}
+
+func BenchmarkAppendMessage_CNAMEChain(b *testing.B) {
+	cnames, ips := []string{"cdn.example.net", "edge.example.net"}, []netip.Addr{benchIP4, benchIP4}
+	benchmarkAppendMessage(b, TypeA, 4, func(dst []byte, req *Message) []byte {
+		return AppendCNAMERecord(dst, req, 300, cnames, ips)
+	})
+}
+
+func BenchmarkAppendMessage_LargeTXT(b *testing.B) {
+	txt := strings.Repeat("v=spf1 include:_spf.example.org ", 7)
+	benchmarkAppendMessage(b, TypeTXT, 1, func(dst []byte, req *Message) []byte {
+		return AppendTXTRecord(dst, req, 300, txt)
+	})
+}
+
+func BenchmarkAppendMessage_20Records(b *testing.B) {
+	benchmarkAppendMessage(b, TypeA, 20, func(dst []byte, req *Message) []byte {
+		return AppendHOSTRecord(dst, req, 300, benchIPs)
+	})
+}
+
+func BenchmarkDecodeDomainInto(b *testing.B) {
+	qname := EncodeDomain(nil, "www.example.org")
+	var dst [256]byte
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		_ = decodeDomainInto(dst[:0], qname)
+	}
+}
+
+func BenchmarkSetQuestion(b *testing.B) {
+	req := AcquireMessage()
+	defer ReleaseMessage(req)
+
+	for i := 0; i < b.N; i++ {
+		req.SetRequestQuestion("mail.google.com", TypeA, ClassINET)
+	}
+}
+
+func BenchmarkSetResponseHeader(b *testing.B) {
+	req := AcquireMessage()
+	defer ReleaseMessage(req)
+
+	req.SetRequestQuestion("mail.google.com", TypeA, ClassINET)
+
+	for i := 0; i < b.N; i++ {
+		req.SetResponseHeader(RcodeNoError, 4)
+	}
+}
+
+func BenchmarkDecodeName(b *testing.B) {
+	payload, _ := hex.DecodeString("8e5281800001000200000000047632657803636f6d0000020001c00c000200010000545f0014036b696d026e730a636c6f7564666c617265c011c00c000200010000545f000704746f6464c02a")
+
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	err := ParseMessage(resp, payload, true)
+	if err != nil {
+		b.Errorf("ParseMessage(%+v) error: %+v", payload, err)
+	}
+
+	var dst [256]byte
+	name := []byte("\x04todd\xc0\x2a")
+	for i := 0; i < b.N; i++ {
+		resp.DecodeName(dst[:0], name)
+	}
+}
+
+func BenchmarkRawRecords(b *testing.B) {
+	payload, _ := hex.DecodeString("8e5281800001000200000000047632657803636f6d0000020001c00c000200010000545f0014036b696d026e730a636c6f7564666c617265c011c00c000200010000545f000704746f6464c02a")
+
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	err := ParseMessage(resp, payload, true)
+	if err != nil {
+		b.Errorf("ParseMessage(%+v) error: %+v", payload, err)
+	}
+
+	var n int
+	for i := 0; i < b.N; i++ {
+		_ = resp.RawRecords(SectionAnswer, func(raw []byte) bool {
+			n += len(raw)
+			return true
+		})
+	}
+}
+
+func BenchmarkRecordsDecodeName(b *testing.B) {
+	payload, _ := hex.DecodeString("8e5281800001000200000000047632657803636f6d0000020001c00c000200010000545f0014036b696d026e730a636c6f7564666c617265c011c00c000200010000545f000704746f6464c02a")
+
+	resp := AcquireMessage()
+	defer ReleaseMessage(resp)
+
+	err := ParseMessage(resp, payload, true)
+	if err != nil {
+		b.Errorf("ParseMessage(%+v) error: %+v", payload, err)
+	}
+
+	var dst [256]byte
+	var n int
+	for i := 0; i < b.N; i++ {
+		for r := range resp.Records {
+			n += len(resp.DecodeName(dst[:0], r.Name)) + len(resp.DecodeName(dst[:0], r.Data))
+		}
+	}
+}
+
+func BenchmarkAppendDomainLower(b *testing.B) {
+	msg := new(Message)
+	msg.SetRequestQuestion("WWW.Example.ORG", TypeA, ClassINET)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var dst [256]byte
+	for i := 0; i < b.N; i++ {
+		_ = msg.AppendDomainLower(dst[:0])
 	}
 }
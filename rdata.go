@@ -0,0 +1,308 @@
+package fastdns
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// URI represents a URI resource record, see RFC 7553.
+type URI struct {
+	Priority uint16
+	Weight   uint16
+	Target   []byte
+}
+
+// AppendURIs appends the URI records in the answer section of msg to dst.
+// The Target of each record references the underlying msg.Raw.
+func (msg *Message) AppendURIs(dst []URI) ([]URI, error) {
+	err := msg.answers(TypeURI, func(data []byte) error {
+		if len(data) < 4 {
+			return ErrInvalidAnswer
+		}
+		dst = append(dst, URI{
+			Priority: uint16(data[0])<<8 | uint16(data[1]),
+			Weight:   uint16(data[2])<<8 | uint16(data[3]),
+			Target:   data[4:],
+		})
+		return nil
+	})
+	return dst, err
+}
+
+// AMTRELAY represents an AMTRELAY resource record, see RFC 8777. The Relay is empty for Type 0,
+// 4 or 16 bytes of the address for Type 1 and 2, and the decoded domain name for Type 3.
+type AMTRELAY struct {
+	Precedence byte
+	Discovery  bool
+	Type       byte
+	Relay      []byte
+}
+
+// AppendAMTRELAY appends the AMTRELAY records in the answer section of msg to dst.
+// The address Relay of each record references the underlying msg.Raw.
+func (msg *Message) AppendAMTRELAY(dst []AMTRELAY) ([]AMTRELAY, error) {
+	err := msg.answers(TypeAMTRELAY, func(data []byte) (err error) {
+		if len(data) < 2 {
+			return ErrInvalidAnswer
+		}
+		relay := AMTRELAY{
+			Precedence: data[0],
+			Discovery:  data[1]&0b10000000 != 0,
+			Type:       data[1] & 0b01111111,
+			Relay:      data[2:],
+		}
+		switch relay.Type {
+		case 0:
+			err = rdataLen(relay.Relay, 0)
+		case 1:
+			err = rdataLen(relay.Relay, 4)
+		case 2:
+			err = rdataLen(relay.Relay, 16)
+		case 3:
+			// the relay name is not compressed
+			if uncompressedNameLen(relay.Relay) != len(relay.Relay) {
+				return ErrInvalidAnswer
+			}
+			relay.Relay, err = msg.decodeName(nil, relay.Relay)
+		}
+		if err != nil {
+			return ErrInvalidAnswer
+		}
+		dst = append(dst, relay)
+		return nil
+	})
+	return dst, err
+}
+
+// SMIMEA represents an SMIMEA resource record, see RFC 8162. It has the same format as TLSA.
+type SMIMEA struct {
+	Usage        byte
+	Selector     byte
+	MatchingType byte
+	Data         []byte
+}
+
+// AppendSMIMEA appends the SMIMEA records in the answer section of msg to dst.
+// The Data of each record references the underlying msg.Raw.
+func (msg *Message) AppendSMIMEA(dst []SMIMEA) ([]SMIMEA, error) {
+	err := msg.answers(TypeSMIMEA, func(data []byte) error {
+		if len(data) < 3 {
+			return ErrInvalidAnswer
+		}
+		dst = append(dst, SMIMEA{
+			Usage:        data[0],
+			Selector:     data[1],
+			MatchingType: data[2],
+			Data:         data[3:],
+		})
+		return nil
+	})
+	return dst, err
+}
+
+// SMIMEAName returns the owner name of the SMIMEA records for the email address localpart@domain,
+// which is the hex of the SHA-256 hash of localpart truncated to 28 bytes, followed by "._smimecert." and domain.
+func SMIMEAName(localpart, domain string) string {
+	sum := sha256.Sum256([]byte(localpart))
+	return hex.EncodeToString(sum[:28]) + "._smimecert." + domain
+}
+
+// ZONEMD represents a ZONEMD resource record, see RFC 8976.
+type ZONEMD struct {
+	Serial   uint32
+	Scheme   byte
+	HashAlgo byte
+	Digest   []byte
+}
+
+// AppendZONEMD appends the ZONEMD records in the answer section of msg to dst.
+// The Digest of each record references the underlying msg.Raw.
+func (msg *Message) AppendZONEMD(dst []ZONEMD) ([]ZONEMD, error) {
+	err := msg.answers(TypeZONEMD, func(data []byte) error {
+		// the digest is at least 12 bytes
+		if len(data) < 6+12 {
+			return ErrInvalidAnswer
+		}
+		dst = append(dst, ZONEMD{
+			Serial:   uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3]),
+			Scheme:   data[4],
+			HashAlgo: data[5],
+			Digest:   data[6:],
+		})
+		return nil
+	})
+	return dst, err
+}
+
+// HIP represents a HIP resource record, see RFC 8005. RendezvousServers are the decoded domain names.
+type HIP struct {
+	PKAlgorithm       byte
+	HIT               []byte
+	PublicKey         []byte
+	RendezvousServers [][]byte
+}
+
+// AppendHIP appends the HIP records in the answer section of msg to dst.
+// The HIT and PublicKey of each record reference the underlying msg.Raw.
+func (msg *Message) AppendHIP(dst []HIP) ([]HIP, error) {
+	err := msg.answers(TypeHIP, func(data []byte) (err error) {
+		if len(data) < 4 {
+			return ErrInvalidAnswer
+		}
+		hitLen, pkLen := int(data[0]), int(data[2])<<8|int(data[3])
+		if 4+hitLen+pkLen > len(data) {
+			return ErrInvalidAnswer
+		}
+		hip := HIP{
+			PKAlgorithm: data[1],
+			HIT:         data[4 : 4+hitLen],
+			PublicKey:   data[4+hitLen : 4+hitLen+pkLen],
+		}
+		// the rendezvous server names are not compressed
+		for data = data[4+hitLen+pkLen:]; len(data) > 0; {
+			n := uncompressedNameLen(data)
+			if n < 0 {
+				return ErrInvalidAnswer
+			}
+			var name []byte
+			if name, err = msg.decodeName(nil, data[:n]); err != nil {
+				return ErrInvalidAnswer
+			}
+			hip.RendezvousServers = append(hip.RendezvousServers, name)
+			data = data[n:]
+		}
+		dst = append(dst, hip)
+		return nil
+	})
+	return dst, err
+}
+
+// TKEY represents a TKEY resource record, see RFC 2930. The Mode 3 is GSS-API negotiation.
+type TKEY struct {
+	Algorithm  []byte
+	Inception  uint32
+	Expiration uint32
+	Mode       uint16
+	Error      uint16
+	Key        []byte
+	OtherData  []byte
+}
+
+// AppendTKEY appends the TKEY records in the answer section of msg to dst.
+// The Key and OtherData of each record reference the underlying msg.Raw.
+func (msg *Message) AppendTKEY(dst []TKEY) ([]TKEY, error) {
+	err := msg.answers(TypeTKEY, func(data []byte) (err error) {
+		// the algorithm name is not compressed
+		n := uncompressedNameLen(data)
+		if n < 0 || n+14 > len(data) {
+			return ErrInvalidAnswer
+		}
+		var tkey TKEY
+		if tkey.Algorithm, err = msg.decodeName(nil, data[:n]); err != nil {
+			return ErrInvalidAnswer
+		}
+		data = data[n:]
+		tkey.Inception = uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+		tkey.Expiration = uint32(data[4])<<24 | uint32(data[5])<<16 | uint32(data[6])<<8 | uint32(data[7])
+		tkey.Mode = uint16(data[8])<<8 | uint16(data[9])
+		tkey.Error = uint16(data[10])<<8 | uint16(data[11])
+		keyLen := int(data[12])<<8 | int(data[13])
+		if data = data[14:]; keyLen+2 > len(data) {
+			return ErrInvalidAnswer
+		}
+		tkey.Key = data[:keyLen]
+		otherLen := int(data[keyLen])<<8 | int(data[keyLen+1])
+		if data = data[keyLen+2:]; otherLen != len(data) {
+			return ErrInvalidAnswer
+		}
+		tkey.OtherData = data
+		dst = append(dst, tkey)
+		return nil
+	})
+	return dst, err
+}
+
+// uncompressedNameLen returns the length of the uncompressed name at the start of data, or -1 if it is
+// truncated or compressed.
+func uncompressedNameLen(data []byte) int {
+	for i := 0; i < len(data); i += int(data[i]) + 1 {
+		switch {
+		case data[i] == 0:
+			return i + 1
+		case data[i]&0xc0 != 0:
+			return -1
+		}
+	}
+	return -1
+}
+
+// CAA represents a CAA resource record, see RFC 8659. Flag 128 is the issuer critical flag.
+type CAA struct {
+	Flag  byte
+	Tag   []byte
+	Value []byte
+}
+
+// AppendCAAs appends the CAA records in the answer section of msg to dst.
+// The Tag and Value of each record reference the underlying msg.Raw.
+func (msg *Message) AppendCAAs(dst []CAA) ([]CAA, error) {
+	err := msg.answers(TypeCAA, func(data []byte) error {
+		// the tag is at least 1 byte
+		if len(data) < 2+1 {
+			return ErrInvalidAnswer
+		}
+		n := 2 + int(data[1])
+		if n > len(data) {
+			return ErrInvalidAnswer
+		}
+		dst = append(dst, CAA{
+			Flag:  data[0],
+			Tag:   data[2:n],
+			Value: data[n:],
+		})
+		return nil
+	})
+	return dst, err
+}
+
+// DecodeTXT appends the concatenated character-strings of the TXT RDATA data to dst. It returns
+// ErrInvalidAnswer if data is empty or a character-string exceeds data.
+func DecodeTXT(dst []byte, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return dst, ErrInvalidAnswer
+	}
+	for len(data) > 0 {
+		n := 1 + int(data[0])
+		if n > len(data) {
+			return dst, ErrInvalidAnswer
+		}
+		dst = append(dst, data[1:n]...)
+		data = data[n:]
+	}
+	return dst, nil
+}
+
+// rdataLen returns ErrInvalidAnswer if data is not n bytes.
+func rdataLen(data []byte, n int) error {
+	if len(data) != n {
+		return ErrInvalidAnswer
+	}
+	return nil
+}
+
+// answers calls f with the RDATA of each answer record of type typ, stops at the first error.
+func (msg *Message) answers(typ Type, f func(data []byte) error) (err error) {
+	walkErr := msg.walk(func(i, off, rdata, end int) bool {
+		if msg.section(i) != SectionAnswer {
+			return false
+		}
+		if r := msg.record(off, rdata, end); r.Type == typ {
+			err = f(r.Data)
+		}
+		return err == nil
+	})
+	if err == nil {
+		err = walkErr
+	}
+	return
+}
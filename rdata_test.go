@@ -0,0 +1,309 @@
+package fastdns
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func mockAnswerMessage(typ Type, rdata ...string) *Message {
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", typ, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, uint16(len(rdata)))
+	for _, s := range rdata {
+		data, err := hex.DecodeString(s)
+		if err != nil {
+			panic(err)
+		}
+		msg.Raw = append(msg.Raw, 0xc0, 0x0c, byte(typ>>8), byte(typ), 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, byte(len(data)>>8), byte(len(data)))
+		msg.Raw = append(msg.Raw, data...)
+	}
+	return msg
+}
+
+func TestMessageAppendURIs(t *testing.T) {
+	// 10 1 "ftp://ftp1.example.com/public"
+	msg := mockAnswerMessage(TypeURI, "000a0001"+hex.EncodeToString([]byte("ftp://ftp1.example.com/public")))
+
+	uris, err := msg.AppendURIs(nil)
+	if err != nil {
+		t.Fatalf("AppendURIs error: %+v", err)
+	}
+	if len(uris) != 1 || uris[0].Priority != 10 || uris[0].Weight != 1 || string(uris[0].Target) != "ftp://ftp1.example.com/public" {
+		t.Errorf("AppendURIs got=%+v", uris)
+	}
+
+	msg = mockAnswerMessage(TypeURI, "000a00")
+	if _, err := msg.AppendURIs(nil); err != ErrInvalidAnswer {
+		t.Errorf("AppendURIs with short rdata shall return ErrInvalidAnswer, got %+v", err)
+	}
+}
+
+func TestMessageAppendAMTRELAY(t *testing.T) {
+	relays := []AMTRELAY{
+		{Precedence: 10, Discovery: false, Type: 0, Relay: []byte{}},
+		{Precedence: 10, Discovery: true, Type: 1, Relay: []byte{203, 0, 113, 15}},
+		{Precedence: 20, Discovery: false, Type: 2, Relay: make([]byte, 16)},
+		{Precedence: 30, Discovery: true, Type: 3, Relay: []byte("amtrelays.example.com")},
+	}
+
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeAMTRELAY, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, uint16(len(relays)))
+	msg.Raw = AppendAMTRELAYRecord(msg.Raw, msg, 300, relays)
+
+	got, err := msg.AppendAMTRELAY(nil)
+	if err != nil {
+		t.Fatalf("AppendAMTRELAY error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, relays) {
+		t.Errorf("AppendAMTRELAY got=%+v want=%+v", got, relays)
+	}
+
+	// short fixed fields, truncated IPv4 relay, truncated and compressed relay names
+	for _, data := range []string{"0a", "0a01010203", "0a03036578", "0a03c00c", "0a0303616d74c00c"} {
+		msg := mockAnswerMessage(TypeAMTRELAY, data)
+		if _, err := msg.AppendAMTRELAY(nil); err != ErrInvalidAnswer {
+			t.Errorf("AppendAMTRELAY(%s) shall return ErrInvalidAnswer, got %+v", data, err)
+		}
+	}
+}
+
+func TestMessageAppendSMIMEA(t *testing.T) {
+	// 3 0 1 with a SHA-256 of the certificate
+	msg := mockAnswerMessage(TypeSMIMEA, "030001"+"d2abde240d7cd3ee6b4b28c54df034b97983a1d16e8a410e4561cb106618e971")
+
+	records, err := msg.AppendSMIMEA(nil)
+	if err != nil {
+		t.Fatalf("AppendSMIMEA error: %+v", err)
+	}
+	if len(records) != 1 || records[0].Usage != 3 || records[0].Selector != 0 || records[0].MatchingType != 1 ||
+		hex.EncodeToString(records[0].Data) != "d2abde240d7cd3ee6b4b28c54df034b97983a1d16e8a410e4561cb106618e971" {
+		t.Errorf("AppendSMIMEA got=%+v", records)
+	}
+
+	msg = mockAnswerMessage(TypeSMIMEA, "0300")
+	if _, err := msg.AppendSMIMEA(nil); err != ErrInvalidAnswer {
+		t.Errorf("AppendSMIMEA with short rdata shall return ErrInvalidAnswer, got %+v", err)
+	}
+}
+
+func TestSMIMEAName(t *testing.T) {
+	// https://www.rfc-editor.org/rfc/rfc8162#section-3
+	if got, want := SMIMEAName("hugh", "example.com"), "c93f1e400f26708f98cb19d936620da35eec8f72e57f9eec01c1afd6._smimecert.example.com"; got != want {
+		t.Errorf("SMIMEAName got=%s want=%s", got, want)
+	}
+}
+
+func TestMessageAppendZONEMD(t *testing.T) {
+	digest := sha512.Sum384([]byte("example."))
+	zonemds := []ZONEMD{
+		{Serial: 2018031900, Scheme: 1, HashAlgo: 1, Digest: digest[:]},
+	}
+
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeZONEMD, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, uint16(len(zonemds)))
+	msg.Raw = AppendZONEMDRecord(msg.Raw, msg, 86400, zonemds)
+
+	got, err := msg.AppendZONEMD(nil)
+	if err != nil {
+		t.Fatalf("AppendZONEMD error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, zonemds) {
+		t.Errorf("AppendZONEMD got=%+v want=%+v", got, zonemds)
+	}
+
+	msg = mockAnswerMessage(TypeZONEMD, "7849f2ec0101"+"00112233445566778899aa")
+	if _, err := msg.AppendZONEMD(nil); err != ErrInvalidAnswer {
+		t.Errorf("AppendZONEMD with short digest shall return ErrInvalidAnswer, got %+v", err)
+	}
+}
+
+func TestMessageAppendCAAs(t *testing.T) {
+	caas := []CAA{
+		{Flag: 0, Tag: []byte("issue"), Value: []byte("letsencrypt.org")},
+		{Flag: 128, Tag: []byte("iodef"), Value: []byte("mailto:security@example.org")},
+	}
+
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeCAA, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, uint16(len(caas)))
+	n := len(msg.Raw)
+	msg.Raw = AppendCAARecord(msg.Raw, msg, 300, caas)
+
+	if got, want := hex.EncodeToString(msg.Raw[n:n+12+22]), "c00c010100010000012c0016"+"0005"+"6973737565"+"6c657473656e63727970742e6f7267"; got != want {
+		t.Errorf("AppendCAARecord(issue letsencrypt.org) got=%s want=%s", got, want)
+	}
+
+	got, err := msg.AppendCAAs(nil)
+	if err != nil {
+		t.Fatalf("AppendCAAs error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, caas) {
+		t.Errorf("AppendCAAs got=%+v want=%+v", got, caas)
+	}
+
+	for _, n := range []int{254, 255} {
+		tag := strings.Repeat("a", n)
+		msg = mockAnswerMessage(TypeCAA, "00"+hex.EncodeToString([]byte{byte(n)})+hex.EncodeToString([]byte(tag))+"00")
+		got, err := msg.AppendCAAs(nil)
+		if err != nil || len(got) != 1 || string(got[0].Tag) != tag || string(got[0].Value) != "\x00" {
+			t.Errorf("AppendCAAs with %d bytes tag got=%+v error=%+v", n, got, err)
+		}
+	}
+
+	msg = mockAnswerMessage(TypeCAA, "0009"+"6973737565")
+	if _, err := msg.AppendCAAs(nil); err != ErrInvalidAnswer {
+		t.Errorf("AppendCAAs with long tag length shall return ErrInvalidAnswer, got %+v", err)
+	}
+}
+
+func TestMessageAppendHIP(t *testing.T) {
+	msg := mockAnswerMessage(TypeHIP,
+		"10020009"+"200100107b1a74df365639cc39f1d578"+"0301000100aabbccdd"+"0472767331076578616d706c6503636f6d00"+"0472767332076578616d706c6503636f6d00",
+		"10020000"+"200100107b1a74df365639cc39f1d578",
+	)
+	hit, _ := hex.DecodeString("200100107b1a74df365639cc39f1d578")
+	want := []HIP{
+		{
+			PKAlgorithm:       2,
+			HIT:               hit,
+			PublicKey:         []byte{0x03, 0x01, 0x00, 0x01, 0x00, 0xaa, 0xbb, 0xcc, 0xdd},
+			RendezvousServers: [][]byte{[]byte("rvs1.example.com"), []byte("rvs2.example.com")},
+		},
+		{
+			PKAlgorithm: 2,
+			HIT:         hit,
+			PublicKey:   []byte{},
+		},
+	}
+
+	got, err := msg.AppendHIP(nil)
+	if err != nil {
+		t.Fatalf("AppendHIP error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AppendHIP got=%+v want=%+v", got, want)
+	}
+
+	for _, rdata := range []string{
+		// short fixed fields
+		"1002",
+		// PK length past RDATA
+		"100200ff" + "200100107b1a74df365639cc39f1d578",
+		// truncated rendezvous server
+		"10020000" + "200100107b1a74df365639cc39f1d578" + "0472767331",
+		// compressed rendezvous server
+		"10020000" + "200100107b1a74df365639cc39f1d578" + "c00c",
+	} {
+		if _, err := mockAnswerMessage(TypeHIP, rdata).AppendHIP(nil); err != ErrInvalidAnswer {
+			t.Errorf("AppendHIP(%s) shall return ErrInvalidAnswer, got %+v", rdata, err)
+		}
+	}
+}
+
+func TestMessageAppendTKEY(t *testing.T) {
+	want := []TKEY{
+		{
+			Algorithm:  []byte("gss-tsig"),
+			Inception:  1700000000,
+			Expiration: 1700086400,
+			Mode:       3,
+			Error:      0,
+			Key:        []byte{0x60, 0x82, 0x01, 0x02},
+			OtherData:  []byte{},
+		},
+		{
+			Algorithm:  []byte("hmac-sha256"),
+			Inception:  1,
+			Expiration: 2,
+			Mode:       2,
+			Error:      uint16(RcodeBADMODE),
+			Key:        []byte{},
+			OtherData:  []byte{0xaa, 0xbb},
+		},
+	}
+
+	var rdata []string
+	for _, tkey := range want {
+		var data []byte
+		for _, label := range strings.Split(string(tkey.Algorithm), ".") {
+			data = append(data, byte(len(label)))
+			data = append(data, label...)
+		}
+		data = append(data, 0,
+			byte(tkey.Inception>>24), byte(tkey.Inception>>16), byte(tkey.Inception>>8), byte(tkey.Inception),
+			byte(tkey.Expiration>>24), byte(tkey.Expiration>>16), byte(tkey.Expiration>>8), byte(tkey.Expiration),
+			byte(tkey.Mode>>8), byte(tkey.Mode), byte(tkey.Error>>8), byte(tkey.Error),
+			byte(len(tkey.Key)>>8), byte(len(tkey.Key)))
+		data = append(data, tkey.Key...)
+		data = append(data, byte(len(tkey.OtherData)>>8), byte(len(tkey.OtherData)))
+		data = append(data, tkey.OtherData...)
+		rdata = append(rdata, hex.EncodeToString(data))
+	}
+
+	got, err := mockAnswerMessage(TypeTKEY, rdata...).AppendTKEY(nil)
+	if err != nil {
+		t.Fatalf("AppendTKEY error: %+v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AppendTKEY got=%+v want=%+v", got, want)
+	}
+
+	for _, rdata := range []string{
+		// truncated algorithm
+		"08677373",
+		// compressed algorithm
+		"c00c" + "00000001000000020003000000000000",
+		// short fixed fields
+		"086773732d7473696700" + "0000000100000002000300",
+		// key length past RDATA
+		"086773732d7473696700" + "000000010000000200030000" + "00ff0000",
+		// other data length past RDATA
+		"086773732d7473696700" + "000000010000000200030000" + "0000" + "0002aa",
+		// trailing bytes
+		"086773732d7473696700" + "000000010000000200030000" + "0000" + "0000ff",
+	} {
+		if _, err := mockAnswerMessage(TypeTKEY, rdata).AppendTKEY(nil); err != ErrInvalidAnswer {
+			t.Errorf("AppendTKEY(%s) shall return ErrInvalidAnswer, got %+v", rdata, err)
+		}
+	}
+}
+
+func TestDecodeTXT(t *testing.T) {
+	var cases = []struct {
+		Hex string
+		TXT string
+		Err error
+	}{
+		{"0568656c6c6f", "hello", nil},
+		{"0568656c6c6f" + "0620776f726c64", "hello world", nil},
+		{"00", "", nil},
+		{"", "", ErrInvalidAnswer},
+		{"0668656c6c6f", "", ErrInvalidAnswer},
+		{"0568656c6c6f" + "05", "hello", ErrInvalidAnswer},
+	}
+
+	for _, c := range cases {
+		data, _ := hex.DecodeString(c.Hex)
+		got, err := DecodeTXT(nil, data)
+		if string(got) != c.TXT || err != c.Err {
+			t.Errorf("DecodeTXT(%s) got=(%q, %+v) want=(%q, %+v)", c.Hex, got, err, c.TXT, c.Err)
+		}
+	}
+
+	// round trip of a long txt split by AppendTXTRecord
+	msg := new(Message)
+	msg.SetRequestQuestion("example.org", TypeTXT, ClassINET)
+	msg.SetResponseHeader(RcodeNoError, 1)
+	txt := "v=DKIM1; k=rsa; p=" + strings.Repeat("MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8A", 12)
+	msg.Raw = AppendTXTRecord(msg.Raw, msg, 300, txt)
+	for r := range msg.Records {
+		if got, err := DecodeTXT(nil, r.Data); string(got) != txt || err != nil {
+			t.Errorf("DecodeTXT(AppendTXTRecord(%d bytes)) got=(%d bytes, %+v)", len(txt), len(got), err)
+		}
+	}
+}
@@ -33,7 +33,8 @@ func AppendSRVRecord(dst []byte, req *Message, ttl uint32, srvs []net.SRV) []byt
 	return dst
 }
 
-// AppendNSRecord appends the NS records to dst and returns the resulting dst.
+// AppendNSRecord appends the NS records owned by the question name to dst and returns the resulting dst.
+// The caller adds len(nameservers) to ANCount for answers, or to NSCount for a delegation in the authority section.
 func AppendNSRecord(dst []byte, req *Message, ttl uint32, nameservers []net.NS) []byte {
 	// NS Records
 	for _, ns := range nameservers {
@@ -138,7 +139,8 @@ func AppendPTRRecord(dst []byte, req *Message, ttl uint32, ptr string) []byte {
 
 // AppendTXTRecord appends the TXT records to dst and returns the resulting dst.
 func AppendTXTRecord(dst []byte, req *Message, ttl uint32, txt string) []byte {
-	length := len(txt) + (len(txt)+0xff)/0x100
+	// one length byte per 255 bytes chunk, and an empty chunk for the empty txt
+	length := len(txt) + max(1, (len(txt)+0xfe)/0xff)
 	dst = append(dst,
 		// NAME
 		0xc0, 0x0c,
@@ -167,3 +169,102 @@ func AppendTXTRecord(dst []byte, req *Message, ttl uint32, txt string) []byte {
 
 	return dst
 }
+
+// AppendCAARecord appends the CAA records to dst and returns the resulting dst.
+func AppendCAARecord(dst []byte, req *Message, ttl uint32, caas []CAA) []byte {
+	// CAA Records
+	for _, caa := range caas {
+		length := 2 + len(caa.Tag) + len(caa.Value)
+		dst = append(dst,
+			// NAME
+			0xc0, 0x0c,
+			// TYPE
+			byte(TypeCAA>>8), byte(TypeCAA&0xff),
+			// CLASS
+			byte(req.Question.Class>>8), byte(req.Question.Class),
+			// TTL
+			byte(ttl>>24), byte(ttl>>16), byte(ttl>>8), byte(ttl),
+			// RDLENGTH
+			byte(length>>8), byte(length),
+			// FLAGS
+			caa.Flag,
+			// TAG LENGTH
+			byte(len(caa.Tag)),
+		)
+		// TAG
+		dst = append(dst, caa.Tag...)
+		// VALUE
+		dst = append(dst, caa.Value...)
+	}
+
+	return dst
+}
+
+// AppendAMTRELAYRecord appends the AMTRELAY records to dst and returns the resulting dst.
+func AppendAMTRELAYRecord(dst []byte, req *Message, ttl uint32, relays []AMTRELAY) []byte {
+	// AMTRELAY Records
+	for _, relay := range relays {
+		length := 2 + len(relay.Relay)
+		if relay.Type == 3 {
+			length += 2
+		}
+		var d byte
+		if relay.Discovery {
+			d = 0b10000000
+		}
+		dst = append(dst,
+			// NAME
+			0xc0, 0x0c,
+			// TYPE
+			byte(TypeAMTRELAY>>8), byte(TypeAMTRELAY&0xff),
+			// CLASS
+			byte(req.Question.Class>>8), byte(req.Question.Class),
+			// TTL
+			byte(ttl>>24), byte(ttl>>16), byte(ttl>>8), byte(ttl),
+			// RDLENGTH
+			byte(length>>8), byte(length),
+			// PRECEDENCE
+			relay.Precedence,
+			// D, TYPE
+			d|relay.Type&0b01111111,
+		)
+		// RELAY
+		if relay.Type == 3 {
+			dst = EncodeDomain(dst, b2s(relay.Relay))
+		} else {
+			dst = append(dst, relay.Relay...)
+		}
+	}
+
+	return dst
+}
+
+// AppendZONEMDRecord appends the ZONEMD records to dst and returns the resulting dst.
+func AppendZONEMDRecord(dst []byte, req *Message, ttl uint32, zonemds []ZONEMD) []byte {
+	// ZONEMD Records
+	for _, zonemd := range zonemds {
+		length := 6 + len(zonemd.Digest)
+		dst = append(dst,
+			// NAME
+			0xc0, 0x0c,
+			// TYPE
+			0x00, byte(TypeZONEMD),
+			// CLASS
+			byte(req.Question.Class>>8), byte(req.Question.Class),
+			// TTL
+			byte(ttl>>24), byte(ttl>>16), byte(ttl>>8), byte(ttl),
+			// RDLENGTH
+			byte(length>>8), byte(length),
+			// SERIAL
+			byte(zonemd.Serial>>24), byte(zonemd.Serial>>16), byte(zonemd.Serial>>8), byte(zonemd.Serial),
+			// SCHEME
+			zonemd.Scheme,
+			// HASH ALGORITHM
+			zonemd.HashAlgo,
+		)
+		// DIGEST
+		dst = append(dst, zonemd.Digest...)
+	}
+
+	return dst
+}
@@ -113,7 +113,7 @@ func AppendCNAMERecord(dst []byte, req *Message, ttl uint32, cnames []string, ip
 		if i == 0 {
 			offset += len(req.Question.Name) + 2 + 2 + 12
 		} else {
-			offset += len(cname) + 2 + 12
+			offset += len(cnames[i-1]) + 2 + 12
 		}
 		// RDATA
 		dst = EncodeDomain(dst, cname)
@@ -1,9 +1,12 @@
 package fastdns
 
 import (
+	"bytes"
 	"encoding/hex"
 	"net"
 	"net/netip"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 )
@@ -37,6 +40,38 @@ func TestAppendHOSTRecord(t *testing.T) {
 
 }
 
+func TestAppendHOSTRecordMixed(t *testing.T) {
+	ips := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("192.0.2.2"),
+	}
+
+	req := new(Message)
+	req.SetRequestQuestion("example.org", TypeA, ClassINET)
+	req.SetResponseHeader(RcodeNoError, uint16(len(ips)))
+	req.Raw = AppendHOSTRecord(req.Raw, req, 300, ips)
+
+	resp := new(Message)
+	if err := ParseAndValidate(resp, req.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", req.Raw, err)
+	}
+
+	var types []Type
+	for r := range resp.Records {
+		if r.TTL != 300 || r.Class != ClassINET || !bytes.Equal(resp.DecodeName(nil, r.Name), []byte("example.org")) {
+			t.Errorf("AppendHOSTRecord(%v) record got=%+v", ips, r)
+		}
+		types = append(types, r.Type)
+	}
+	if got, want := types, []Type{TypeA, TypeAAAA, TypeA}; !slices.Equal(got, want) {
+		t.Errorf("AppendHOSTRecord(%v) types got=%v want=%v", ips, got, want)
+	}
+	if got := resp.AppendIPs(nil, false); !slices.Equal(got, ips) {
+		t.Errorf("AppendHOSTRecord(%v) round trip got=%v", ips, got)
+	}
+}
+
 func TestAppendCNAMERecord(t *testing.T) {
 	cases := []struct {
 		Hex    string
@@ -57,7 +92,7 @@ func TestAppendCNAMERecord(t *testing.T) {
 			300,
 		},
 		{
-			"c00c000500010000012c00090470687573026c7500c028000500010000012c000c02686b0470687573026c7500c040000100010000012c000401010101c040000100010000012c000408080808",
+			"c00c000500010000012c00090470687573026c7500c028000500010000012c000c02686b0470687573026c7500c03d000100010000012c000401010101c03d000100010000012c000408080808",
 			[]string{"phus.lu", "hk.phus.lu"},
 			[]netip.Addr{netip.AddrFrom4([4]byte{1, 1, 1, 1}), netip.AddrFrom4([4]byte{8, 8, 8, 8})},
 			300,
@@ -76,6 +111,75 @@ func TestAppendCNAMERecord(t *testing.T) {
 
 }
 
+func TestAppendCNAMERecordDecode(t *testing.T) {
+	cnames := []string{"alias.example.net", "edge.cdn.example.com"}
+	ips := []netip.Addr{netip.MustParseAddr("192.0.2.1")}
+
+	req := new(Message)
+	req.SetRequestQuestion("www.example.org", TypeA, ClassINET)
+	req.SetResponseHeader(RcodeNoError, uint16(len(cnames)+len(ips)))
+	req.Raw = AppendCNAMERecord(req.Raw, req, 300, cnames, ips)
+
+	resp := new(Message)
+	if err := ParseAndValidate(resp, req.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", req.Raw, err)
+	}
+
+	owner := "www.example.org"
+	var targets []string
+	for r := range resp.Records {
+		if got := string(resp.DecodeName(nil, r.Name)); got != owner {
+			t.Errorf("AppendCNAMERecord(%v) owner got=%s want=%s", cnames, got, owner)
+		}
+		if r.Type == TypeCNAME {
+			owner = string(resp.DecodeName(nil, r.Data))
+			targets = append(targets, owner)
+		}
+	}
+	if !slices.Equal(targets, cnames) {
+		t.Errorf("AppendCNAMERecord(%v) targets got=%v", cnames, targets)
+	}
+	if got := resp.AppendIPs(nil, false); !slices.Equal(got, ips) {
+		t.Errorf("AppendCNAMERecord(%v) ips got=%v want=%v", cnames, got, ips)
+	}
+}
+
+func TestAppendTXTRecordSplit(t *testing.T) {
+	req := new(Message)
+	req.SetRequestQuestion("example.org", TypeTXT, ClassINET)
+
+	for _, n := range []int{0, 255, 300, 510, 511} {
+		txt := strings.Repeat("x", n)
+
+		req.SetResponseHeader(RcodeNoError, 1)
+		req.Raw = AppendTXTRecord(req.Raw, req, 300, txt)
+
+		resp := new(Message)
+		if err := ParseAndValidate(resp, req.Raw); err != nil {
+			t.Errorf("AppendTXTRecord(%d bytes) error: %+v", n, err)
+			continue
+		}
+
+		var chunks []int
+		var got []byte
+		for r := range resp.Records {
+			for data := r.Data; len(data) > 0; data = data[1+int(data[0]):] {
+				if 1+int(data[0]) > len(data) {
+					t.Fatalf("AppendTXTRecord(%d bytes) truncated RDATA %x", n, r.Data)
+				}
+				chunks = append(chunks, int(data[0]))
+				got = append(got, data[1:1+int(data[0])]...)
+			}
+		}
+		if string(got) != txt {
+			t.Errorf("AppendTXTRecord(%d bytes) got %d bytes", n, len(got))
+		}
+		if n == 300 && !slices.Equal(chunks, []int{255, 45}) {
+			t.Errorf("AppendTXTRecord(300 bytes) chunks got=%v want=[255 45]", chunks)
+		}
+	}
+}
+
 func TestAppendSRVRecord(t *testing.T) {
 	cases := []struct {
 		Hex string
@@ -105,6 +209,40 @@ func TestAppendSRVRecord(t *testing.T) {
 
 }
 
+func TestAppendSRVRecordDecode(t *testing.T) {
+	srvs := []net.SRV{
+		{Target: "sip1.example.org", Port: 5060, Priority: 10, Weight: 60},
+		{Target: "sip2.example.org", Port: 5061, Priority: 20, Weight: 0},
+	}
+
+	req := new(Message)
+	req.SetRequestQuestion("_sip._tcp.example.org", TypeSRV, ClassINET)
+	req.SetResponseHeader(RcodeNoError, uint16(len(srvs)))
+	req.Raw = AppendSRVRecord(req.Raw, req, 300, srvs)
+
+	resp := new(Message)
+	if err := ParseAndValidate(resp, req.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", req.Raw, err)
+	}
+
+	var got []net.SRV
+	for r := range resp.Records {
+		if r.Type != TypeSRV || len(r.Data) != 6+len(srvs[len(got)].Target)+2 {
+			t.Errorf("AppendSRVRecord(%v) record got=%+v", srvs, r)
+			continue
+		}
+		got = append(got, net.SRV{
+			Target:   string(resp.DecodeName(nil, r.Data[6:])),
+			Port:     uint16(r.Data[4])<<8 | uint16(r.Data[5]),
+			Priority: uint16(r.Data[0])<<8 | uint16(r.Data[1]),
+			Weight:   uint16(r.Data[2])<<8 | uint16(r.Data[3]),
+		})
+	}
+	if !reflect.DeepEqual(got, srvs) {
+		t.Errorf("AppendSRVRecord(%v) decode got=%v", srvs, got)
+	}
+}
+
 func TestAppendNSRecord(t *testing.T) {
 	cases := []struct {
 		Hex         string
@@ -135,6 +273,37 @@ func TestAppendNSRecord(t *testing.T) {
 
 }
 
+func TestAppendNSRecordAuthority(t *testing.T) {
+	nameservers := []net.NS{{Host: "ns1.example.org"}, {Host: "ns2.example.net"}}
+
+	req := new(Message)
+	req.SetRequestQuestion("example.org", TypeNS, ClassINET)
+	req.SetResponseHeader(RcodeNoError, 0)
+	req.Header.NSCount = uint16(len(nameservers))
+	req.Raw = AppendNSRecord(req.Raw, req, 300, nameservers)
+	// rewrite the header in place for NSCOUNT
+	_ = req.AppendHeader(req.Raw[:0])
+
+	resp := new(Message)
+	if err := ParseAndValidate(resp, req.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", req.Raw, err)
+	}
+
+	var got []net.NS
+	_ = resp.RawRecords(SectionAuthority, func(raw []byte) bool {
+		typ, data, err := RawRDATA(raw)
+		if err != nil || typ != TypeNS {
+			t.Errorf("AppendNSRecord(%v) record got=(%x, %+v)", nameservers, raw, err)
+			return false
+		}
+		got = append(got, net.NS{Host: string(resp.DecodeName(nil, data))})
+		return true
+	})
+	if !reflect.DeepEqual(got, nameservers) {
+		t.Errorf("AppendNSRecord(%v) decode got=%v", nameservers, got)
+	}
+}
+
 func TestAppendSOARecord(t *testing.T) {
 	cases := []struct {
 		Hex     string
@@ -172,6 +341,42 @@ func TestAppendSOARecord(t *testing.T) {
 
 }
 
+func TestAppendSOARecordDecode(t *testing.T) {
+	mname, rname := net.NS{Host: "ns1.example.org"}, net.NS{Host: "hostmaster.example.org"}
+	want := []uint32{2024010101, 7200, 3600, 1209600, 60}
+
+	req := new(Message)
+	req.SetRequestQuestion("example.org", TypeSOA, ClassINET)
+	req.SetResponseHeader(RcodeNoError, 1)
+	req.Raw = AppendSOARecord(req.Raw, req, 300, mname, rname, want[0], want[1], want[2], want[3], want[4])
+
+	resp := new(Message)
+	if err := ParseAndValidate(resp, req.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", req.Raw, err)
+	}
+
+	for r := range resp.Records {
+		if r.Type != TypeSOA || r.TTL != 300 || len(r.Data) != len(mname.Host)+2+len(rname.Host)+2+20 {
+			t.Fatalf("AppendSOARecord() record got=%+v", r)
+		}
+		off := skipName(r.Data, 0)
+		if got := string(resp.DecodeName(nil, r.Data[:off])); got != mname.Host {
+			t.Errorf("AppendSOARecord() mname got=%s want=%s", got, mname.Host)
+		}
+		end := skipName(r.Data, off)
+		if got := string(resp.DecodeName(nil, r.Data[off:end])); got != rname.Host {
+			t.Errorf("AppendSOARecord() rname got=%s want=%s", got, rname.Host)
+		}
+		var got []uint32
+		for b := r.Data[end:]; len(b) >= 4; b = b[4:] {
+			got = append(got, uint32(b[0])<<24|uint32(b[1])<<16|uint32(b[2])<<8|uint32(b[3]))
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("AppendSOARecord() serial, refresh, retry, expire and minimum got=%v want=%v", got, want)
+		}
+	}
+}
+
 func TestAppendMXRecord(t *testing.T) {
 	cases := []struct {
 		Hex string
@@ -201,6 +406,35 @@ func TestAppendMXRecord(t *testing.T) {
 
 }
 
+func TestAppendMXRecordDecode(t *testing.T) {
+	mxs := []net.MX{{Host: "mx1.example.org", Pref: 10}, {Host: "backup.mx.example.net", Pref: 20}}
+
+	req := new(Message)
+	req.SetRequestQuestion("example.org", TypeMX, ClassINET)
+	req.SetResponseHeader(RcodeNoError, uint16(len(mxs)))
+	req.Raw = AppendMXRecord(req.Raw, req, 300, mxs)
+
+	resp := new(Message)
+	if err := ParseAndValidate(resp, req.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", req.Raw, err)
+	}
+
+	var got []net.MX
+	for r := range resp.Records {
+		if r.Type != TypeMX || len(r.Data) != 2+len(mxs[len(got)].Host)+2 {
+			t.Errorf("AppendMXRecord(%v) record got=%+v", mxs, r)
+			continue
+		}
+		got = append(got, net.MX{
+			Host: string(resp.DecodeName(nil, r.Data[2:])),
+			Pref: uint16(r.Data[0])<<8 | uint16(r.Data[1]),
+		})
+	}
+	if !reflect.DeepEqual(got, mxs) {
+		t.Errorf("AppendMXRecord(%v) decode got=%v", mxs, got)
+	}
+}
+
 func TestAppendPTRRecord(t *testing.T) {
 	cases := []struct {
 		Hex string
@@ -230,6 +464,30 @@ func TestAppendPTRRecord(t *testing.T) {
 
 }
 
+func TestAppendPTRRecordReverse(t *testing.T) {
+	req := new(Message)
+	req.SetRequestQuestion("1.0.0.127.in-addr.arpa", TypePTR, ClassINET)
+	req.SetResponseHeader(RcodeNoError, 1)
+	req.Raw = AppendPTRRecord(req.Raw, req, 300, "localhost")
+
+	if got, want := hex.EncodeToString(req.Raw[12+len(req.Question.Name)+4:]), "c00c000c00010000012c000b096c6f63616c686f737400"; got != want {
+		t.Errorf("AppendPTRRecord(localhost) got=%s want=%s", got, want)
+	}
+
+	resp := new(Message)
+	if err := ParseAndValidate(resp, req.Raw); err != nil {
+		t.Fatalf("ParseAndValidate(%x) error: %+v", req.Raw, err)
+	}
+	for r := range resp.Records {
+		if got := string(resp.DecodeName(nil, r.Name)); got != "1.0.0.127.in-addr.arpa" {
+			t.Errorf("AppendPTRRecord(localhost) owner got=%s", got)
+		}
+		if got := string(resp.DecodeName(nil, r.Data)); r.Type != TypePTR || got != "localhost" {
+			t.Errorf("AppendPTRRecord(localhost) got=%s %s", r.Type, got)
+		}
+	}
+}
+
 func TestAppendTXTRecord(t *testing.T) {
 	cases := []struct {
 		Hex string
@@ -192,6 +192,12 @@ func serveCtx(ctx *udpCtx) error {
 
 	rw, req := ctx.rw, ctx.req
 
+	// QR = 1, a response is dropped without reply, so that the server is not a reflector
+	if len(req.Raw) > 2 && req.Raw[2]&0b10000000 != 0 {
+		udpCtxPool.Put(ctx)
+		return ErrInvalidHeader
+	}
+
 	err := ParseMessage(req, req.Raw, false)
 	if err != nil {
 		Error(rw, req, RcodeFormErr)
@@ -123,6 +123,65 @@ func TestServerParseMessageError(t *testing.T) {
 	_, _ = conn.Write([]byte{0x00, 0x02, 0x01, 0x00, 0x00, 0x00})
 }
 
+func TestServerDropResponse(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	s := &Server{
+		Handler:  &mockServerHandler{},
+		ErrorLog: slog.Default(),
+		MaxProcs: 1,
+	}
+
+	addr := allocAddr()
+	if addr == "" {
+		t.Errorf("allocAddr() failed.")
+	}
+
+	go func() {
+		err := s.ListenAndServe(addr)
+		if err != nil {
+			t.Errorf("listen %+v error: %+v", addr, err)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatalf("dial to %+v return error: %+v", addr, err)
+	}
+	defer conn.Close()
+
+	for _, payload := range [][]byte{
+		// a response without question and with an answer of example.org A 1.2.4.8
+		{0x00, 0x02, 0x81, 0x80, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'o', 'r', 'g', 0x00, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x01, 0x2c, 0x00, 0x04, 1, 2, 4, 8},
+		// a response with the question example.org A
+		{0x00, 0x03, 0x81, 0x80, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'o', 'r', 'g', 0x00, 0x00, 0x01, 0x00, 0x01},
+	} {
+		if _, err := conn.Write(payload); err != nil {
+			t.Fatalf("write to %+v return error: %+v", addr, err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		buf := make([]byte, 512)
+		if n, err := conn.Read(buf); err == nil {
+			t.Errorf("serve %x shall drop the response without reply, got %x", payload, buf[:n])
+		}
+	}
+
+	// the server still answers queries after dropping responses
+	query := []byte{0x00, 0x04, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x07, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0x03, 'o', 'r', 'g', 0x00, 0x00, 0x01, 0x00, 0x01}
+	if _, err := conn.Write(query); err != nil {
+		t.Fatalf("write to %+v return error: %+v", addr, err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 512)
+	if n, err := conn.Read(buf); err != nil || n < 12 || buf[0] != 0x00 || buf[1] != 0x04 || buf[7] != 1 {
+		t.Errorf("serve %x got=%x error=%+v", query, buf[:n], err)
+	}
+}
+
 func TestServerForkHost(t *testing.T) {
 	if runtime.GOOS == "windows" {
 		// On Windows, the resolver always uses C library functions, such as GetAddrInfo and DnsQuery.
@@ -2,6 +2,8 @@ package fastdns
 
 import (
 	"net/netip"
+	"strconv"
+	"strings"
 )
 
 // Rcode denotes a 4bit field that specifies the response
@@ -73,7 +75,8 @@ func (c Rcode) String() string {
 	case RcodeBADCOOKIE:
 		return "BadCookie"
 	}
-	return ""
+	// RFC 3597 only defines TYPEnnn and CLASSnnn, unknown rcodes are presented in a form modeled on them
+	return "RCODE" + strconv.Itoa(int(c))
 }
 
 // Opcode denotes a 4bit field that specified the query type.
@@ -101,7 +104,8 @@ func (c Opcode) String() string {
 	case OpcodeUpdate:
 		return "Update"
 	}
-	return ""
+	// RFC 3597 only defines TYPEnnn and CLASSnnn, unknown opcodes are presented in a form modeled on them
+	return "OPCODE" + strconv.Itoa(int(c))
 }
 
 // Flags is an arbitrary 16bit represents QR, Opcode, AA, TC, RD, RA, Z and RCODE.
@@ -181,7 +185,8 @@ func (c Class) String() string {
 	case ClassANY:
 		return "ANY"
 	}
-	return ""
+	// unknown classes are presented as in RFC 3597
+	return "CLASS" + strconv.Itoa(int(c))
 }
 
 // Type is a DNS type.
@@ -263,6 +268,7 @@ const (
 	TypeURI        Type = 256
 	TypeCAA        Type = 257
 	TypeAVC        Type = 258
+	TypeAMTRELAY   Type = 260
 	TypeTKEY       Type = 249
 	TypeTSIG       Type = 250
 	TypeIXFR       Type = 251
@@ -425,6 +431,8 @@ func (t Type) String() string {
 		return "CAA"
 	case TypeAVC:
 		return "AVC"
+	case TypeAMTRELAY:
+		return "AMTRELAY"
 	case TypeTKEY:
 		return "TKEY"
 	case TypeTSIG:
@@ -446,11 +454,28 @@ func (t Type) String() string {
 	case TypeReserved:
 		return "Reserved"
 	}
-	return ""
+	// unknown types are presented as in RFC 3597
+	return "TYPE" + strconv.Itoa(int(t))
 }
 
-// ParseType converts a question type string into a question type value.
-func ParseType(s string) (t Type) {
+// ParseType converts a type mnemonic such as "AAAA" or the RFC 3597 generic form such as "TYPE65"
+// into a type value, the mnemonic is case-insensitive. ok is false if s is not a known type.
+func ParseType(s string) (t Type, ok bool) {
+	if t = parseType(s); t != 0 {
+		return t, true
+	}
+	if t = parseType(strings.ToUpper(s)); t != 0 {
+		return t, true
+	}
+	if len(s) > 4 && strings.EqualFold(s[:4], "TYPE") {
+		if n, err := strconv.ParseUint(s[4:], 10, 16); err == nil {
+			return Type(n), true
+		}
+	}
+	return 0, false
+}
+
+func parseType(s string) (t Type) {
 	switch s {
 	case "A", "a":
 		t = TypeA
@@ -598,6 +623,8 @@ func ParseType(s string) (t Type) {
 		t = TypeCAA
 	case "AVC", "avc":
 		t = TypeAVC
+	case "AMTRELAY", "amtrelay":
+		t = TypeAMTRELAY
 	case "TKEY", "tkey":
 		t = TypeTKEY
 	case "TSIG", "tsig":
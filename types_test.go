@@ -29,7 +29,8 @@ func TestRcode(t *testing.T) {
 		{RcodeBADALG, "BadAlg"},
 		{RcodeBADTRUNC, "BadTrunc"},
 		{RcodeBADCOOKIE, "BadCookie"},
-		{Rcode(253), ""},
+		{Rcode(11), "RCODE11"},
+		{Rcode(253), "RCODE253"},
 	}
 
 	for _, c := range cases {
@@ -49,7 +50,8 @@ func TestOpcode(t *testing.T) {
 		{OpcodeStatus, "Status"},
 		{OpcodeNotify, "Notify"},
 		{OpcodeUpdate, "Update"},
-		{Opcode(255), ""},
+		{Opcode(3), "OPCODE3"},
+		{Opcode(255), "OPCODE255"},
 	}
 
 	for _, c := range cases {
@@ -70,7 +72,8 @@ func TestClass(t *testing.T) {
 		{ClassHESIOD, "HS"},
 		{ClassNONE, "NONE"},
 		{ClassANY, "ANY"},
-		{Class(253), ""},
+		{Class(253), "CLASS253"},
+		{Class(1232), "CLASS1232"},
 	}
 
 	for _, c := range cases {
@@ -159,6 +162,7 @@ func TestType(t *testing.T) {
 		{TypeURI, "URI"},
 		{TypeCAA, "CAA"},
 		{TypeAVC, "AVC"},
+		{TypeAMTRELAY, "AMTRELAY"},
 		{TypeTKEY, "TKEY"},
 		{TypeTSIG, "TSIG"},
 		{TypeIXFR, "IXFR"},
@@ -169,7 +173,8 @@ func TestType(t *testing.T) {
 		{TypeTA, "TA"},
 		{TypeDLV, "DLV"},
 		{TypeReserved, "Reserved"},
-		{Type(65534), ""},
+		{Type(65534), "TYPE65534"},
+		{Type(65280), "TYPE65280"},
 	}
 
 	for _, c := range cases {
@@ -178,3 +183,28 @@ func TestType(t *testing.T) {
 		}
 	}
 }
+
+func TestParseType(t *testing.T) {
+	var cases = []struct {
+		String string
+		Type   Type
+		OK     bool
+	}{
+		{"A", TypeA, true},
+		{"aaaa", TypeAAAA, true},
+		{"Https", TypeHTTPS, true},
+		{"TYPE65", TypeHTTPS, true},
+		{"type65280", Type(65280), true},
+		{"TYPE65536", 0, false},
+		{"TYPE", 0, false},
+		{"TYPEx", 0, false},
+		{"foo", 0, false},
+		{"", 0, false},
+	}
+
+	for _, c := range cases {
+		if typ, ok := ParseType(c.String); typ != c.Type || ok != c.OK {
+			t.Errorf("ParseType(%#v) error got=(%v, %v) want=(%v, %v)", c.String, typ, ok, c.Type, c.OK)
+		}
+	}
+}
@@ -27,9 +27,70 @@ func EncodeDomain(dst []byte, domain string) []byte {
 	return dst
 }
 
+// NormalizeDomain returns domain without a single trailing dot, so that it is encoded by EncodeDomain
+// and SetRequestQuestion as expected. It returns ErrInvalidName if domain is empty, has an empty label
+// or a label longer than 63 bytes, or is longer than 253 bytes.
+func NormalizeDomain(domain string) (string, error) {
+	if n := len(domain); n != 0 && domain[n-1] == '.' {
+		domain = domain[:n-1]
+	}
+	if domain == "" || len(domain) > 253 {
+		return "", ErrInvalidName
+	}
+
+	n := 0
+	for i := 0; i < len(domain); i++ {
+		if domain[i] != '.' {
+			n++
+			continue
+		}
+		if n == 0 || n > 63 {
+			return "", ErrInvalidName
+		}
+		n = 0
+	}
+	if n == 0 || n > 63 {
+		return "", ErrInvalidName
+	}
+
+	return domain, nil
+}
+
+// lower converts ASCII upper case letters in b to lower case in place.
+func lower(b []byte) {
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+}
+
+// equalFold reports whether a and b are equal under ASCII case folding.
+func equalFold(a, b []byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		x, y := a[i], b[i]
+		if 'A' <= x && x <= 'Z' {
+			x += 'a' - 'A'
+		}
+		if 'A' <= y && y <= 'Z' {
+			y += 'a' - 'A'
+		}
+		if x != y {
+			return false
+		}
+	}
+	return true
+}
+
 // nolint
 func b2s(b []byte) string { return *(*string)(unsafe.Pointer(&b)) }
 
+// s2b converts a string to a byte slice without memory allocation, the slice must not be modified.
+func s2b(s string) []byte { return unsafe.Slice(unsafe.StringData(s), len(s)) }
+
 // cheaprandn returns a pseudorandom uint32 in [0,n).
 //
 //go:noescape
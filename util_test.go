@@ -2,6 +2,7 @@ package fastdns
 
 import (
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -21,6 +22,33 @@ func TestEncodeDomain(t *testing.T) {
 	}
 }
 
+func TestNormalizeDomain(t *testing.T) {
+	var cases = []struct {
+		Domain     string
+		Normalized string
+		Error      error
+	}{
+		{"example.com", "example.com", nil},
+		{"example.com.", "example.com", nil},
+		{"example..com", "", ErrInvalidName},
+		{"example.com..", "", ErrInvalidName},
+		{".example.com", "", ErrInvalidName},
+		{"", "", ErrInvalidName},
+		{".", "", ErrInvalidName},
+		{strings.Repeat("a", 64) + ".com", "", ErrInvalidName},
+	}
+
+	for _, c := range cases {
+		got, err := NormalizeDomain(c.Domain)
+		if got != c.Normalized || err != c.Error {
+			t.Errorf("NormalizeDomain(%q) got=%q,%v want=%q,%v", c.Domain, got, err, c.Normalized, c.Error)
+		}
+		if err == nil && string(EncodeDomain(nil, got)) != string(EncodeDomain(nil, "example.com")) {
+			t.Errorf("EncodeDomain(NormalizeDomain(%q)) got=%x", c.Domain, EncodeDomain(nil, got))
+		}
+	}
+}
+
 func TestListen(t *testing.T) {
 	if runtime.GOOS != "linux" {
 		return
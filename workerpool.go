@@ -1,6 +1,7 @@
 package fastdns
 
 import (
+	"errors"
 	"log/slog"
 	"runtime"
 	"sync"
@@ -221,7 +222,7 @@ func (wp *workerPool) workerFunc(ch *workerChan) {
 		}
 
 		if err = wp.WorkerFunc(item.ctx); err != nil {
-			if wp.LogAllErrors || !(err == ErrInvalidHeader || err == ErrInvalidQuestion) {
+			if wp.LogAllErrors || !(errors.Is(err, ErrInvalidHeader) || errors.Is(err, ErrInvalidQuestion)) {
 				if wp.Logger != nil {
 					wp.Logger.Error("error when serving connection", "error", err, "local_addr", item.ctx.rw.Conn.LocalAddr(), "remote_addr", item.ctx.rw.AddrPort)
 				}
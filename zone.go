@@ -0,0 +1,485 @@
+package fastdns
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidZone is returned by LoadZone if a line of the zone file is malformed or not supported.
+var ErrInvalidZone = errors.New("dns zone file has a malformed or unsupported entry")
+
+// ZoneError is returned by LoadZone, it describes the line of the zone file where loading failed.
+type ZoneError struct {
+	Line int
+	Err  error
+}
+
+func (e *ZoneError) Error() string {
+	return "dns zone at line " + strconv.Itoa(e.Line) + ": " + e.Err.Error()
+}
+
+func (e *ZoneError) Unwrap() error {
+	return e.Err
+}
+
+// Zone is a set of records loaded by LoadZone, which answers the queries for the names at or below Origin authoritatively,
+// and refers the queries below a delegation to its name servers.
+type Zone struct {
+	// Origin is the lowercased origin of the zone without the trailing dot, it is empty for the root zone.
+	Origin string
+
+	// records maps the lowercased owner names to their records, empty non-terminals map to nil.
+	records map[string][]zoneRecord
+
+	// soa is the SOA record of the zone apex, it is nil if the zone file has none.
+	soa *zoneRecord
+}
+
+// zoneRecord is a record of a Zone with its RDATA in the uncompressed wire format.
+type zoneRecord struct {
+	Type Type
+	TTL  uint32
+	Data []byte
+}
+
+// LoadZone loads a zone file in a simplified RFC 1035 master file format from r, origin is the initial $ORIGIN.
+// The $ORIGIN and $TTL directives, comments, parentheses, quoted strings, relative and absolute names, "@" and
+// the A, AAAA, CNAME, NS, MX, TXT and SOA records of the IN class are supported. A record without TTL uses
+// the last $TTL, or 3600 before any $TTL. Errors are returned as *ZoneError.
+func LoadZone(r io.Reader, origin string) (*Zone, error) {
+	z := &Zone{records: make(map[string][]zoneRecord)}
+
+	p := zoneParser{ttl: 3600}
+	if err := p.setOrigin(origin); err != nil {
+		return nil, &ZoneError{Line: 0, Err: err}
+	}
+	z.Origin = p.origin
+	z.records[z.Origin] = nil
+
+	scanner := bufio.NewScanner(r)
+
+	var fields []string
+	var blank bool
+	var depth, line, start int
+	for scanner.Scan() {
+		line++
+		text := scanner.Text()
+		if depth == 0 {
+			// a line starting with a blank owns its records by the previous owner
+			start, fields = line, fields[:0]
+			blank = text != "" && (text[0] == ' ' || text[0] == '\t')
+		}
+
+		var err error
+		if fields, depth, err = appendZoneFields(fields, text, depth); err != nil {
+			return nil, &ZoneError{Line: line, Err: err}
+		}
+		if depth > 0 || len(fields) == 0 {
+			continue
+		}
+
+		if err := p.parse(z, fields, blank); err != nil {
+			return nil, &ZoneError{Line: start, Err: err}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, &ZoneError{Line: line, Err: err}
+	}
+	if depth > 0 {
+		return nil, &ZoneError{Line: start, Err: ErrInvalidZone}
+	}
+
+	return z, nil
+}
+
+// Lookup sets resp to the authoritative response of req and reports whether the query name is in the zone,
+// resp is untouched if it is not. The answers are the records of the query type at the query name, or
+// the CNAME record there, which is not followed. A NXDOMAIN or NODATA response carries the SOA record of
+// the zone in the authority section. A query name at or below a delegation by NS records other than at the
+// apex gets a referral, with AA=0, the NS records in the authority section and their glue in the additional
+// section. RA is always 0.
+func (z *Zone) Lookup(req *Message, resp *Message) bool {
+	if req.Question.Class != ClassINET || !inZone(req.Domain, s2b(z.Origin)) {
+		return false
+	}
+
+	var buf [256]byte
+	name := append(buf[:0], req.Domain...)
+	lower(name)
+
+	resp.SetResponse(req)
+
+	// AA = 1, RA = 0
+	resp.Header.Flags |= 0b0000010000000000
+	resp.Header.Flags &= 0b1111111101111111
+
+	if cut := z.delegation(string(name)); cut != "" {
+		// a referral is not authoritative
+		resp.Header.Flags &= 0b1111101111111111
+		z.appendReferral(resp, cut)
+	} else {
+		z.appendAnswers(resp, string(name), req.Question.Type)
+	}
+
+	// rewrite the header for the flags and the record counts
+	var header [12]byte
+	copy(resp.Raw, resp.AppendHeader(header[:0]))
+
+	return true
+}
+
+// delegation returns the topmost name between the origin, exclusive, and name, inclusive, which owns NS records,
+// or "" if name is not at or below a zone cut.
+func (z *Zone) delegation(name string) (cut string) {
+	for len(name) > len(z.Origin) {
+		for _, rr := range z.records[name] {
+			if rr.Type == TypeNS {
+				cut = name
+				break
+			}
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+	}
+	return
+}
+
+// appendAnswers appends the authoritative answers of name and qtype to resp, or the SOA record for a negative response.
+func (z *Zone) appendAnswers(resp *Message, name string, qtype Type) {
+	rrs, exists := z.records[name]
+
+	for _, rr := range rrs {
+		if rr.Type != qtype && qtype != TypeANY && rr.Type != TypeCNAME {
+			continue
+		}
+		// NAME
+		resp.Raw = append(resp.Raw, 0xc0, 0x0c)
+		resp.Raw = appendZoneRecord(resp.Raw, rr.Type, rr.TTL, rr.Data)
+		resp.Header.ANCount++
+	}
+
+	if resp.Header.ANCount != 0 {
+		return
+	}
+	if !exists {
+		resp.Header.Flags |= Flags(RcodeNXDomain)
+	}
+	if z.soa != nil {
+		// the negative TTL of RFC 2308 is the minimum of the SOA TTL and MINIMUM
+		ttl := z.soa.TTL
+		if minimum := uint32(z.soa.Data[len(z.soa.Data)-4])<<24 | uint32(z.soa.Data[len(z.soa.Data)-3])<<16 |
+			uint32(z.soa.Data[len(z.soa.Data)-2])<<8 | uint32(z.soa.Data[len(z.soa.Data)-1]); minimum < ttl {
+			ttl = minimum
+		}
+		resp.Raw = appendZoneName(resp.Raw, z.Origin)
+		resp.Raw = appendZoneRecord(resp.Raw, TypeSOA, ttl, z.soa.Data)
+		resp.Header.NSCount = 1
+	}
+}
+
+// appendReferral appends the NS records of the zone cut to the authority section of resp, and the A and AAAA
+// records of the name servers in the zone to the additional section as glue.
+func (z *Zone) appendReferral(resp *Message, cut string) {
+	var m Message
+	var buf [256]byte
+
+	for _, rr := range z.records[cut] {
+		if rr.Type != TypeNS {
+			continue
+		}
+		resp.Raw = appendZoneName(resp.Raw, cut)
+		resp.Raw = appendZoneRecord(resp.Raw, TypeNS, rr.TTL, rr.Data)
+		resp.Header.NSCount++
+	}
+
+	for _, ns := range z.records[cut] {
+		if ns.Type != TypeNS {
+			continue
+		}
+		host := m.DecodeName(buf[:0], ns.Data)
+		lower(host)
+		for _, rr := range z.records[string(host)] {
+			if rr.Type != TypeA && rr.Type != TypeAAAA {
+				continue
+			}
+			resp.Raw = appendZoneName(resp.Raw, string(host))
+			resp.Raw = appendZoneRecord(resp.Raw, rr.Type, rr.TTL, rr.Data)
+			resp.Header.ARCount++
+		}
+	}
+}
+
+// appendZoneRecord appends the TYPE, CLASS, TTL, RDLENGTH and RDATA of a record of the IN class to dst.
+func appendZoneRecord(dst []byte, typ Type, ttl uint32, data []byte) []byte {
+	dst = append(dst,
+		// TYPE
+		byte(typ>>8), byte(typ),
+		// CLASS
+		byte(ClassINET>>8), byte(ClassINET),
+		// TTL
+		byte(ttl>>24), byte(ttl>>16), byte(ttl>>8), byte(ttl),
+		// RDLENGTH
+		byte(len(data)>>8), byte(len(data)),
+	)
+	// RDATA
+	return append(dst, data...)
+}
+
+// add adds the record of owner to z, and registers the names between owner and the origin as empty non-terminals.
+func (z *Zone) add(owner string, rr zoneRecord) {
+	z.records[owner] = append(z.records[owner], rr)
+	if rr.Type == TypeSOA && owner == z.Origin && z.soa == nil {
+		z.soa = &rr
+	}
+
+	for name := owner; len(name) > len(z.Origin); {
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[i+1:]
+		if _, ok := z.records[name]; !ok {
+			z.records[name] = nil
+		}
+	}
+}
+
+// zoneParser keeps the state of LoadZone between the entries of a zone file.
+type zoneParser struct {
+	origin string
+	owner  string
+	ttl    uint32
+	seen   bool
+}
+
+// setOrigin sets the origin to the absolute name s.
+func (p *zoneParser) setOrigin(s string) (err error) {
+	if s == "" || s == "." {
+		p.origin = ""
+		return nil
+	}
+	if p.origin, err = NormalizeDomain(s); err != nil {
+		return err
+	}
+	p.origin = strings.ToLower(p.origin)
+	return nil
+}
+
+// name returns the dotted form of the name s in the zone file, which is absolute if it ends with a dot,
+// "@" for the origin, or otherwise relative to the origin.
+func (p *zoneParser) name(s string) (string, error) {
+	switch {
+	case s == "":
+		return "", ErrInvalidZone
+	case s == "@":
+		return p.origin, nil
+	case s == ".":
+		return "", nil
+	case s[len(s)-1] != '.' && p.origin != "":
+		s += "." + p.origin
+	}
+	return NormalizeDomain(s)
+}
+
+// parse parses the fields of an entry of the zone file and adds its record to z.
+func (p *zoneParser) parse(z *Zone, fields []string, blank bool) error {
+	switch strings.ToUpper(fields[0]) {
+	case "$ORIGIN":
+		if len(fields) != 2 {
+			return ErrInvalidZone
+		}
+		name, err := p.name(fields[1])
+		if err != nil {
+			return err
+		}
+		p.origin = strings.ToLower(name)
+		return nil
+	case "$TTL":
+		if len(fields) != 2 {
+			return ErrInvalidZone
+		}
+		ttl, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return ErrInvalidZone
+		}
+		p.ttl = uint32(ttl)
+		return nil
+	}
+	if strings.HasPrefix(fields[0], "$") {
+		return ErrInvalidZone
+	}
+
+	if !blank {
+		owner, err := p.name(fields[0])
+		if err != nil {
+			return err
+		}
+		p.owner, p.seen, fields = strings.ToLower(owner), true, fields[1:]
+	}
+	if !p.seen || !inZone(s2b(p.owner), s2b(z.Origin)) {
+		return ErrInvalidZone
+	}
+
+	// the TTL and the class are optional and in any order
+	ttl := p.ttl
+	for i := 0; i < 2 && len(fields) > 0; i++ {
+		if strings.EqualFold(fields[0], "IN") {
+			fields = fields[1:]
+		} else if n, err := strconv.ParseUint(fields[0], 10, 32); err == nil {
+			ttl, fields = uint32(n), fields[1:]
+		}
+	}
+	if len(fields) == 0 {
+		return ErrInvalidZone
+	}
+
+	typ, ok := ParseType(fields[0])
+	if !ok {
+		return ErrInvalidZone
+	}
+
+	data, err := p.rdata(nil, typ, fields[1:])
+	if err != nil {
+		return err
+	}
+	if len(data) > 0xffff {
+		return ErrInvalidZone
+	}
+
+	z.add(p.owner, zoneRecord{Type: typ, TTL: ttl, Data: data})
+	return nil
+}
+
+// rdata appends the uncompressed RDATA of the record of typ in the presentation format fields to dst.
+func (p *zoneParser) rdata(dst []byte, typ Type, fields []string) ([]byte, error) {
+	var names int
+	switch typ {
+	case TypeA, TypeAAAA:
+		if len(fields) != 1 {
+			return nil, ErrInvalidZone
+		}
+		ip, err := netip.ParseAddr(fields[0])
+		switch {
+		case err != nil:
+			return nil, ErrInvalidZone
+		case typ == TypeA && ip.Is4():
+			b := ip.As4()
+			return append(dst, b[:]...), nil
+		case typ == TypeAAAA && ip.Is6() && ip.Zone() == "":
+			b := ip.As16()
+			return append(dst, b[:]...), nil
+		}
+		return nil, ErrInvalidZone
+	case TypeCNAME, TypeNS:
+		if names = 1; len(fields) != names {
+			return nil, ErrInvalidZone
+		}
+	case TypeMX:
+		if names = 1; len(fields) != 2 {
+			return nil, ErrInvalidZone
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return nil, ErrInvalidZone
+		}
+		dst, fields = append(dst, byte(pref>>8), byte(pref)), fields[1:]
+	case TypeSOA:
+		if names = 2; len(fields) != 7 {
+			return nil, ErrInvalidZone
+		}
+	case TypeTXT:
+		if len(fields) == 0 {
+			return nil, ErrInvalidZone
+		}
+		for _, s := range fields {
+			if len(s) > 0xff {
+				return nil, ErrInvalidZone
+			}
+			dst = append(append(dst, byte(len(s))), s...)
+		}
+		return dst, nil
+	default:
+		return nil, ErrInvalidZone
+	}
+
+	for _, s := range fields[:names] {
+		name, err := p.name(s)
+		if err != nil {
+			return nil, err
+		}
+		dst = appendZoneName(dst, name)
+	}
+
+	// SERIAL, REFRESH, RETRY, EXPIRE and MINIMUM of SOA
+	for _, s := range fields[names:] {
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return nil, ErrInvalidZone
+		}
+		dst = append(dst, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	return dst, nil
+}
+
+// appendZoneName appends the uncompressed wire format of the dotted name to dst, the empty name is the root.
+func appendZoneName(dst []byte, name string) []byte {
+	if name == "" {
+		return append(dst, 0)
+	}
+	return EncodeDomain(dst, name)
+}
+
+// appendZoneFields appends the fields of a line of a zone file to fields, depth is the nesting
+// of parentheses which continue an entry over lines. Quoted strings are a single field without
+// the quotes, a backslash escapes the next character, and a semicolon starts a comment.
+func appendZoneFields(fields []string, line string, depth int) ([]string, int, error) {
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case ' ', '\t', '\r':
+			continue
+		case ';':
+			return fields, depth, nil
+		case '(':
+			depth++
+			continue
+		case ')':
+			if depth--; depth < 0 {
+				return nil, 0, ErrInvalidZone
+			}
+			continue
+		case '"':
+			b.Reset()
+			for i++; i < len(line) && line[i] != '"'; i++ {
+				if line[i] == '\\' && i+1 < len(line) {
+					i++
+				}
+				b.WriteByte(line[i])
+			}
+			if i == len(line) {
+				return nil, 0, ErrInvalidZone
+			}
+			fields = append(fields, b.String())
+			continue
+		}
+
+		b.Reset()
+		for ; i < len(line) && !strings.ContainsRune(" \t\r;()\"", rune(line[i])); i++ {
+			if line[i] == '\\' && i+1 < len(line) {
+				i++
+			}
+			b.WriteByte(line[i])
+		}
+		i--
+		fields = append(fields, b.String())
+	}
+	return fields, depth, nil
+}
@@ -0,0 +1,176 @@
+package fastdns
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testZone = `$ORIGIN example.org.
+$TTL 300
+@	IN	SOA	ns1 hostmaster.example.org. (
+		2024010101 ; serial
+		7200       ; refresh
+		3600       ; retry
+		1209600    ; expire
+		60 )       ; minimum
+	IN	NS	ns1
+	IN	MX	10 mail.example.org.
+ns1	600	IN	A	192.0.2.1
+www	IN	A	192.0.2.10
+	IN	A	192.0.2.11
+	IN	AAAA	2001:db8::10
+ftp	CNAME	www
+txt	TXT	"hello world" "v=spf1 -all"
+$ORIGIN sub
+host.deep	A	192.0.2.20
+`
+
+func TestLoadZone(t *testing.T) {
+	zone, err := LoadZone(strings.NewReader(testZone), "example.org")
+	if err != nil {
+		t.Fatalf("LoadZone() error: %+v", err)
+	}
+	if zone.Origin != "example.org" {
+		t.Errorf("LoadZone() origin got=%s want=example.org", zone.Origin)
+	}
+
+	var cases = []struct {
+		Domain  string
+		Type    Type
+		Rcode   Rcode
+		ANCount uint16
+		NSCount uint16
+		RDATA   string
+	}{
+		{"www.example.org", TypeA, RcodeNoError, 2, 0, "c000020a"},
+		{"WWW.Example.ORG", TypeAAAA, RcodeNoError, 1, 0, "20010db8000000000000000000000010"},
+		{"ftp.example.org", TypeA, RcodeNoError, 1, 0, "03777777076578616d706c65036f726700"},
+		{"txt.example.org", TypeTXT, RcodeNoError, 1, 0, "0b68656c6c6f20776f726c640b763d73706631202d616c6c"},
+		{"example.org", TypeMX, RcodeNoError, 1, 0, "000a046d61696c076578616d706c65036f726700"},
+		{"example.org", TypeNS, RcodeNoError, 1, 0, "036e7331076578616d706c65036f726700"},
+		{"host.deep.sub.example.org", TypeA, RcodeNoError, 1, 0, "c0000214"},
+		{"www.example.org", TypeMX, RcodeNoError, 0, 1, ""},
+		{"sub.example.org", TypeA, RcodeNoError, 0, 1, ""},
+		{"nope.example.org", TypeA, RcodeNXDomain, 0, 1, ""},
+	}
+
+	for _, c := range cases {
+		req, resp := new(Message), new(Message)
+		req.SetRequestQuestion(c.Domain, c.Type, ClassINET)
+
+		if !zone.Lookup(req, resp) {
+			t.Errorf("Lookup(%s, %s) got=false want=true", c.Domain, c.Type)
+			continue
+		}
+
+		got := new(Message)
+		if err := ParseAndValidate(got, resp.Raw); err != nil {
+			t.Errorf("Lookup(%s, %s) response %x error: %+v", c.Domain, c.Type, resp.Raw, err)
+			continue
+		}
+		if got.Header.ID != req.Header.ID || got.Header.Flags.AA() != 1 || got.Header.Flags.RA() != 0 || got.Header.Flags.Rcode() != c.Rcode {
+			t.Errorf("Lookup(%s, %s) header got=%+v", c.Domain, c.Type, got.Header)
+		}
+		if got.Header.ANCount != c.ANCount || got.Header.NSCount != c.NSCount {
+			t.Errorf("Lookup(%s, %s) counts got=%d/%d want=%d/%d", c.Domain, c.Type, got.Header.ANCount, got.Header.NSCount, c.ANCount, c.NSCount)
+		}
+		for r := range got.Records {
+			if r.Type == TypeSOA {
+				// the negative TTL is the SOA MINIMUM
+				if r.TTL != 60 {
+					t.Errorf("Lookup(%s, %s) SOA TTL got=%d want=60", c.Domain, c.Type, r.TTL)
+				}
+				continue
+			}
+			if got, want := hex.EncodeToString(r.Data), c.RDATA; got != want {
+				t.Errorf("Lookup(%s, %s) RDATA got=%s want=%s", c.Domain, c.Type, got, want)
+			}
+			break
+		}
+	}
+
+	req, resp := new(Message), new(Message)
+	req.SetRequestQuestion("example.com", TypeA, ClassINET)
+	if zone.Lookup(req, resp) || resp.Raw != nil {
+		t.Errorf("Lookup(example.com) got=true want=false")
+	}
+}
+
+func TestZoneReferral(t *testing.T) {
+	zone, err := LoadZone(strings.NewReader(testZone+`$ORIGIN example.org.
+child	NS	ns1.child
+	NS	ns.example.net.
+ns1.child	A	192.0.2.53
+	AAAA	2001:db8::53
+www.child	A	192.0.2.80
+`), "example.org")
+	if err != nil {
+		t.Fatalf("LoadZone() error: %+v", err)
+	}
+
+	for _, domain := range []string{"child.example.org", "www.child.example.org", "nope.child.example.org"} {
+		req, resp := new(Message), new(Message)
+		req.SetRequestQuestion(domain, TypeA, ClassINET)
+		if !zone.Lookup(req, resp) {
+			t.Fatalf("Lookup(%s) got=false want=true", domain)
+		}
+
+		got := new(Message)
+		if err := ParseAndValidate(got, resp.Raw); err != nil {
+			t.Fatalf("Lookup(%s) response %x error: %+v", domain, resp.Raw, err)
+		}
+		if got.Header.Flags.AA() != 0 || got.Header.Flags.RA() != 0 || got.Header.Flags.Rcode() != RcodeNoError {
+			t.Errorf("Lookup(%s) header got=%+v", domain, got.Header)
+		}
+		if got.Header.ANCount != 0 || got.Header.NSCount != 2 || got.Header.ARCount != 2 {
+			t.Errorf("Lookup(%s) counts got=%d/%d/%d want=0/2/2", domain, got.Header.ANCount, got.Header.NSCount, got.Header.ARCount)
+		}
+
+		var names, glue []string
+		for r := range got.Records {
+			if r.Type == TypeNS {
+				names = append(names, string(got.DecodeName(nil, r.Name))+" "+string(got.DecodeName(nil, r.Data)))
+			}
+		}
+		_ = got.walk(func(i, off, rdata, end int) bool {
+			if i >= 2 {
+				r := got.record(off, rdata, end)
+				addr, _ := ParseAddr(r.Type, r.Data)
+				glue = append(glue, string(got.DecodeName(nil, r.Name))+" "+addr.String())
+			}
+			return true
+		})
+		if want := []string{"child.example.org ns1.child.example.org", "child.example.org ns.example.net"}; !reflect.DeepEqual(names, want) {
+			t.Errorf("Lookup(%s) authority got=%q want=%q", domain, names, want)
+		}
+		if want := []string{"ns1.child.example.org 192.0.2.53", "ns1.child.example.org 2001:db8::53"}; !reflect.DeepEqual(glue, want) {
+			t.Errorf("Lookup(%s) glue got=%q want=%q", domain, glue, want)
+		}
+	}
+}
+
+func TestLoadZoneError(t *testing.T) {
+	var cases = []struct {
+		Zone string
+		Line int
+	}{
+		{"www A 192.0.2.1\nwww A 2001:db8::1\n", 2},
+		{"$INCLUDE other.zone\n", 1},
+		{"www SRV 0 0 80 www\n", 1},
+		{"www A 192.0.2.1\nwww.example.com. A 192.0.2.1\n", 2},
+		{"@ SOA ns1 hostmaster ( 1 2 3 4\n", 1},
+		{"www TXT \"unterminated\n", 1},
+		{"\tA 192.0.2.1\n", 1},
+	}
+
+	for _, c := range cases {
+		_, err := LoadZone(strings.NewReader(c.Zone), "example.org.")
+		var zerr *ZoneError
+		if !errors.As(err, &zerr) || zerr.Line != c.Line {
+			t.Errorf("LoadZone(%q) error got=%+v want line %d", c.Zone, err, c.Line)
+		}
+	}
+}